@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"log"
+	"os"
 	"time"
 
 	"github.com/abelanger5/postgres-fast-inserts/internal/cmdutils"
@@ -62,6 +64,28 @@ var basicCopyFromCmd = &cobra.Command{
 	},
 }
 
+var basicValuesCmd = &cobra.Command{
+	Use:   "values",
+	Short: "values performs inserts by writing n rows in multi-row INSERT ... VALUES statements, split to stay under the bind parameter limit.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := cmdutils.NewInterruptContext()
+		defer cancel()
+
+		runValues(ctx)
+	},
+}
+
+var basicCopyStreamCmd = &cobra.Command{
+	Use:   "copystream",
+	Short: "copystream performs inserts by streaming rows encoded as CSV or text with COPY ... FROM STDIN, or from an existing file.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := cmdutils.NewInterruptContext()
+		defer cancel()
+
+		runCopyStream(ctx, copyStreamFormat)
+	},
+}
+
 var basicCount int
 
 func init() {
@@ -71,6 +95,8 @@ func init() {
 	basicCmd.AddCommand(basicBatchCmd)
 	basicCmd.AddCommand(basicBulkCmd)
 	basicCmd.AddCommand(basicCopyFromCmd)
+	basicCmd.AddCommand(basicValuesCmd)
+	basicCmd.AddCommand(basicCopyStreamCmd)
 
 	basicCmd.PersistentFlags().IntVarP(
 		&basicCount,
@@ -79,6 +105,15 @@ func init() {
 		1000,
 		"number of rows to insert",
 	)
+
+	basicCopyStreamCmd.Flags().AddFlagSet(copyFormatFlags)
+
+	basicCopyStreamCmd.Flags().StringVar(
+		&copyStreamFile,
+		"file",
+		"",
+		"stream rows of (args, idempotency_key) from this file in the given format instead of generating them",
+	)
 }
 
 func runSingleton(ctx context.Context) {
@@ -145,7 +180,7 @@ func runUnnest(ctx context.Context) {
 	start := time.Now()
 	reporter := NewReporter()
 
-	taskArgs := [][]byte{}
+	taskArgs := []json.RawMessage{}
 	taskKeys := []string{}
 
 	for i := 0; i < basicCount; i++ {
@@ -171,6 +206,32 @@ func runUnnest(ctx context.Context) {
 	reporter.Print(time.Since(start))
 }
 
+func runValues(ctx context.Context) {
+	start := time.Now()
+	reporter := NewReporter()
+
+	tasks := make([]TaskParams, 0, basicCount)
+
+	for i := 0; i < basicCount; i++ {
+		tasks = append(tasks, newTaskParams())
+	}
+
+	if _, err := insertValues(ctx, tasks); err != nil {
+		log.Fatal(err)
+	}
+
+	for i := 0; i < basicCount; i++ {
+		reporter.RecordTask(time.Since(start))
+	}
+
+	// one statement per chunk of rows
+	for i := 0; i < basicCount; i += maxValuesRows {
+		reporter.RecordBatch()
+	}
+
+	reporter.Print(time.Since(start))
+}
+
 func runCopyFrom(ctx context.Context) {
 	start := time.Now()
 	reporter := NewReporter()
@@ -203,3 +264,52 @@ func runCopyFrom(ctx context.Context) {
 
 	reporter.Print(time.Since(start))
 }
+
+func runCopyStream(ctx context.Context, format copyFormat) {
+	start := time.Now()
+	reporter := NewReporter()
+
+	var stats copyStats
+
+	if copyStreamFile != "" {
+		f, err := os.Open(copyStreamFile)
+
+		if err != nil {
+			log.Fatalf("could not open file: %v", err)
+		}
+
+		defer f.Close()
+
+		r := &countingReader{r: f}
+
+		stats.rows, err = copyFromReader(ctx, r, format)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		stats.bytes = r.n
+	} else {
+		tasks := make([]TaskParams, 0, basicCount)
+
+		for i := 0; i < basicCount; i++ {
+			tasks = append(tasks, newTaskParams())
+		}
+
+		var err error
+
+		stats, err = copyStream(ctx, tasks, format)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for i := int64(0); i < stats.rows; i++ {
+		reporter.RecordTask(time.Since(start))
+	}
+
+	reporter.RecordCopy(stats)
+	reporter.RecordBatch()
+	reporter.Print(time.Since(start))
+}
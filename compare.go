@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// compareCmd compares JSON reports written with --json
+var compareCmd = &cobra.Command{
+	Use:   "compare <baseline.json> <report.json>...",
+	Short: "compare prints the deltas between a baseline report and one or more other reports.",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+
+		return runCompare(args)
+	},
+}
+
+var throughputThreshold float64
+var latencyThreshold float64
+var compareHTMLFile string
+
+func init() {
+	rootCmd.AddCommand(compareCmd)
+
+	compareCmd.Flags().Float64Var(
+		&throughputThreshold,
+		"throughput-threshold",
+		5,
+		"maximum allowed drop in throughput, in percent of the baseline",
+	)
+
+	compareCmd.Flags().Float64Var(
+		&latencyThreshold,
+		"latency-threshold",
+		10,
+		"maximum allowed increase in p50, p90 and p99 end-to-end latency, in percent of the baseline",
+	)
+
+	compareCmd.Flags().StringVar(
+		&compareHTMLFile,
+		"html",
+		"",
+		"write an HTML report overlaying the runs, with throughput vs. batch size and flush interval charts for sweeps",
+	)
+}
+
+// comparedMetric extracts a single value from a report
+type comparedMetric struct {
+	name string
+
+	value func(r *ReportData) float64
+
+	// isDuration is true if the value is a number of nanoseconds
+	isDuration bool
+
+	// higherIsBetter is true for metrics such as throughput
+	higherIsBetter bool
+
+	// threshold is a pointer to the regression threshold in percent, or nil if the
+	// metric is not gated
+	threshold *float64
+}
+
+func parseReportDuration(s string) float64 {
+	d, err := time.ParseDuration(s)
+
+	if err != nil {
+		return 0
+	}
+
+	return float64(d)
+}
+
+var comparedMetrics = []comparedMetric{
+	{
+		name:           "throughput (rows/s)",
+		value:          func(r *ReportData) float64 { return r.Throughput },
+		higherIsBetter: true,
+		threshold:      &throughputThreshold,
+	},
+	{
+		name:  "tasks executed",
+		value: func(r *ReportData) float64 { return float64(r.TaskCount) },
+	},
+	{
+		name:  "tasks failed",
+		value: func(r *ReportData) float64 { return float64(r.FailedCount) },
+	},
+	{
+		name:       "total time",
+		value:      func(r *ReportData) float64 { return parseReportDuration(r.TotalTime) },
+		isDuration: true,
+	},
+	{
+		name:       "avg latency",
+		value:      func(r *ReportData) float64 { return parseReportDuration(r.AvgLatency) },
+		isDuration: true,
+	},
+	{
+		name:       "p50 latency",
+		value:      func(r *ReportData) float64 { return parseReportDuration(r.Latency.P50) },
+		isDuration: true,
+		threshold:  &latencyThreshold,
+	},
+	{
+		name:       "p90 latency",
+		value:      func(r *ReportData) float64 { return parseReportDuration(r.Latency.P90) },
+		isDuration: true,
+		threshold:  &latencyThreshold,
+	},
+	{
+		name:       "p99 latency",
+		value:      func(r *ReportData) float64 { return parseReportDuration(r.Latency.P99) },
+		isDuration: true,
+		threshold:  &latencyThreshold,
+	},
+	{
+		name:       "p99.9 latency",
+		value:      func(r *ReportData) float64 { return parseReportDuration(r.Latency.P999) },
+		isDuration: true,
+	},
+	{
+		name:       "max latency",
+		value:      func(r *ReportData) float64 { return parseReportDuration(r.Latency.Max) },
+		isDuration: true,
+	},
+	{
+		name:  "batches",
+		value: func(r *ReportData) float64 { return float64(r.NumBatches) },
+	},
+	{
+		name:  "avg batch size",
+		value: func(r *ReportData) float64 { return float64(r.AvgBatchSize) },
+	},
+	{
+		name:  "throughput CV",
+		value: func(r *ReportData) float64 { return r.CV },
+	},
+}
+
+func readReport(path string) (*ReportData, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var report ReportData
+
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("could not parse report %s: %w", path, err)
+	}
+
+	return &report, nil
+}
+
+func formatMetric(m comparedMetric, v float64) string {
+	if m.isDuration {
+		return time.Duration(v).String()
+	}
+
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+func formatDelta(m comparedMetric, delta float64) string {
+	sign := ""
+
+	if delta > 0 {
+		sign = "+"
+	}
+
+	if m.isDuration {
+		return sign + time.Duration(delta).String()
+	}
+
+	return sign + strconv.FormatFloat(delta, 'f', 2, 64)
+}
+
+func runCompare(paths []string) error {
+	reports := make([]*ReportData, len(paths))
+
+	for i, path := range paths {
+		report, err := readReport(path)
+
+		if err != nil {
+			return err
+		}
+
+		reports[i] = report
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "metric\t%s", filepath.Base(paths[0]))
+
+	for _, path := range paths[1:] {
+		fmt.Fprintf(w, "\t%s\tdelta\tdelta %%", filepath.Base(path))
+	}
+
+	fmt.Fprintln(w)
+
+	regressions := []string{}
+
+	for _, m := range comparedMetrics {
+		base := m.value(reports[0])
+
+		fmt.Fprintf(w, "%s\t%s", m.name, formatMetric(m, base))
+
+		for i, report := range reports[1:] {
+			v := m.value(report)
+			delta := v - base
+
+			pct := "n/a"
+
+			if base != 0 {
+				change := delta / base * 100
+				pct = fmt.Sprintf("%+.2f%%", change)
+
+				// a regression is a drop for higher-is-better metrics and a rise otherwise
+				regression := change
+
+				if m.higherIsBetter {
+					regression = -change
+				}
+
+				if m.threshold != nil && regression > *m.threshold {
+					pct += " !"
+
+					regressions = append(regressions, fmt.Sprintf(
+						"%s: %s regressed by %.2f%% (threshold %.2f%%)",
+						filepath.Base(paths[i+1]), m.name, regression, *m.threshold,
+					))
+				}
+			}
+
+			fmt.Fprintf(w, "\t%s\t%s\t%s", formatMetric(m, v), formatDelta(m, delta), pct)
+		}
+
+		fmt.Fprintln(w)
+	}
+
+	w.Flush()
+
+	if compareHTMLFile != "" {
+		named := make([]namedReport, len(reports))
+
+		for i, report := range reports {
+			named[i] = namedReport{Name: filepath.Base(paths[i]), Report: *report}
+		}
+
+		if err := writeHTMLReport(compareHTMLFile, named); err != nil {
+			return fmt.Errorf("could not write HTML report: %w", err)
+		}
+	}
+
+	if len(regressions) > 0 {
+		fmt.Println()
+
+		for _, r := range regressions {
+			fmt.Println(r)
+		}
+
+		return fmt.Errorf("%d metric(s) exceeded the regression threshold", len(regressions))
+	}
+
+	return nil
+}
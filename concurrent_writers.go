@@ -52,6 +52,39 @@ var concurrentCopyFromCmd = &cobra.Command{
 	},
 }
 
+var concurrentUnnestCmd = &cobra.Command{
+	Use:   "unnest",
+	Short: "unnest performs inserts by writing n rows within a single tx in a single database trip with an unnest strategy.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := cmdutils.NewInterruptContext()
+		defer cancel()
+
+		runConcurrentUnnest(ctx)
+	},
+}
+
+var concurrentValuesCmd = &cobra.Command{
+	Use:   "values",
+	Short: "values performs inserts by writing n rows in multi-row INSERT ... VALUES statements, split to stay under the bind parameter limit.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := cmdutils.NewInterruptContext()
+		defer cancel()
+
+		runConcurrentValues(ctx)
+	},
+}
+
+var concurrentCopyStreamCmd = &cobra.Command{
+	Use:   "copystream",
+	Short: "copystream performs inserts by streaming rows encoded as CSV or text with COPY ... FROM STDIN.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := cmdutils.NewInterruptContext()
+		defer cancel()
+
+		runConcurrentTasks(ctx, insertCopyStream(nil, copyStreamFormat))
+	},
+}
+
 var concurrentRowsCount int
 var concurrentWritersCount int
 
@@ -61,6 +94,9 @@ func init() {
 	concurrentCmd.AddCommand(concurrentSingletonCmd)
 	concurrentCmd.AddCommand(concurrentBatchCmd)
 	concurrentCmd.AddCommand(concurrentCopyFromCmd)
+	concurrentCmd.AddCommand(concurrentUnnestCmd)
+	concurrentCmd.AddCommand(concurrentValuesCmd)
+	concurrentCmd.AddCommand(concurrentCopyStreamCmd)
 
 	concurrentCmd.PersistentFlags().IntVarP(
 		&concurrentRowsCount,
@@ -77,6 +113,22 @@ func init() {
 		10,
 		"number of concurrent writers",
 	)
+
+	concurrentUnnestCmd.Flags().BoolVar(
+		&withAssociatedData,
+		"with-associated-data",
+		false,
+		"insert associated data with the task",
+	)
+
+	concurrentValuesCmd.Flags().BoolVar(
+		&withAssociatedData,
+		"with-associated-data",
+		false,
+		"insert associated data with the task",
+	)
+
+	concurrentCopyStreamCmd.Flags().AddFlagSet(copyFormatFlags)
 }
 
 func runConcurrentSingleton(ctx context.Context) {
@@ -231,3 +283,64 @@ func runConcurrentCopyfrom(ctx context.Context) {
 
 	log.Printf("Inserted %d rows in %s", count, elapsed)
 }
+
+func runConcurrentUnnest(ctx context.Context) {
+	if withAssociatedData {
+		runConcurrentTasks(ctx, insertUnnestWithAssociatedData)
+	} else {
+		runConcurrentTasks(ctx, insertUnnest)
+	}
+}
+
+func runConcurrentValues(ctx context.Context) {
+	if withAssociatedData {
+		runConcurrentTasks(ctx, insertValuesWithAssociatedData)
+	} else {
+		runConcurrentTasks(ctx, insertValues)
+	}
+}
+
+// runConcurrentTasks splits the rows between the writers, each of which writes its
+// share with a single call to insertFunc
+func runConcurrentTasks(ctx context.Context, insertFunc func(context.Context, []TaskParams) ([]*dbsqlc.Task, error)) {
+	start := time.Now()
+
+	wg := sync.WaitGroup{}
+	count := 0
+	countMu := sync.Mutex{}
+
+	for i := 0; i < concurrentWritersCount; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			batchSize := concurrentRowsCount / concurrentWritersCount
+			remainder := concurrentRowsCount % concurrentWritersCount
+
+			if i < remainder {
+				batchSize++
+			}
+
+			tasks := []TaskParams{}
+
+			for j := 0; j < batchSize; j++ {
+				tasks = append(tasks, newTaskParams())
+			}
+
+			if _, err := insertFunc(ctx, tasks); err != nil {
+				log.Fatal(err)
+			}
+
+			countMu.Lock()
+			count += len(tasks)
+			countMu.Unlock()
+		}(i)
+	}
+
+	wg.Wait()
+
+	elapsed := time.Since(start)
+
+	log.Printf("Inserted %d rows in %s", count, elapsed)
+}
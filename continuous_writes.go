@@ -2,12 +2,17 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"sync"
 	"time"
 
 	"github.com/abelanger5/postgres-fast-inserts/internal/cmdutils"
 	"github.com/abelanger5/postgres-fast-inserts/internal/dbsqlc"
+	"github.com/abelanger5/postgres-fast-inserts/pkg/buffer"
+	"github.com/jackc/pgx/v5"
 	"github.com/spf13/cobra"
 )
 
@@ -50,6 +55,50 @@ var continuousCopyFromCmd = &cobra.Command{
 	},
 }
 
+var continuousUnnestCmd = &cobra.Command{
+	Use:   "unnest",
+	Short: "unnest performs inserts by writing n rows within a single tx in a single database trip with an unnest strategy.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := cmdutils.NewInterruptContext()
+		defer cancel()
+
+		runContinuousUnnest(ctx)
+	},
+}
+
+var continuousValuesCmd = &cobra.Command{
+	Use:   "values",
+	Short: "values performs inserts by writing n rows in multi-row INSERT ... VALUES statements, split to stay under the bind parameter limit.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := cmdutils.NewInterruptContext()
+		defer cancel()
+
+		runContinuousValues(ctx)
+	},
+}
+
+var continuousCopyStreamCmd = &cobra.Command{
+	Use:   "copystream",
+	Short: "copystream performs inserts by streaming rows encoded as CSV or text with COPY ... FROM STDIN.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := cmdutils.NewInterruptContext()
+		defer cancel()
+
+		runContinuousCopyStream(ctx)
+	},
+}
+
+var continuousPipelineCmd = &cobra.Command{
+	Use:   "pipeline",
+	Short: "pipeline performs inserts by keeping several multi-row VALUES batches in flight on each connection with pipeline mode.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := cmdutils.NewInterruptContext()
+		defer cancel()
+
+		runContinuousPipeline(ctx)
+	},
+}
+
 var continuousPingCmd = &cobra.Command{
 	Use:   "ping",
 	Short: "ping performs a ping to the database instead of a write, to determine baseline performance.",
@@ -62,9 +111,10 @@ var continuousPingCmd = &cobra.Command{
 }
 
 func runContinuousSingleton(ctx context.Context) {
-	// Create a data generator
-	generator := NewDataGenerator(channelBufferSize)
 	reporter := NewReporter()
+
+	// Create a data generator
+	generator := newGenerator(reporter)
 	semaphore := make(chan struct{}, continuousWritersCount)
 
 	// Set up context with timeout
@@ -73,6 +123,13 @@ func runContinuousSingleton(ctx context.Context) {
 
 	generator.Start(ctx)
 
+	if reportInterval > 0 {
+		reporter.StartIntervals(timeoutCtx, reportInterval)
+	}
+
+	stopMetrics := serveMetrics(reporter, nil)
+	defer stopMetrics()
+
 	wg := sync.WaitGroup{}
 	count := 0
 	countMu := sync.Mutex{}
@@ -87,6 +144,8 @@ func runContinuousSingleton(ctx context.Context) {
 		insertFunc = insertSingletonBasicWithAssociatedData
 	}
 
+	retry := retryPolicy()
+
 outer:
 	for {
 		select {
@@ -97,6 +156,14 @@ outer:
 				break outer
 			}
 
+			// time spent waiting for a free writer counts as queue wait, and in open-loop
+			// mode so does any delay past the task's scheduled time
+			receivedTime := time.Now()
+
+			if !task.IntendedAt.IsZero() {
+				receivedTime = task.IntendedAt
+			}
+
 			semaphore <- struct{}{}
 
 			wg.Add(1)
@@ -110,14 +177,17 @@ outer:
 				singletonCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 				defer cancel()
 
-				err := insertFunc(singletonCtx, dbsqlc.InsertTaskSingletonParams{
-					Args:           task.Args,
-					IdempotencyKey: task.IdempotencyKey,
+				retries, retryTime, err := retry.Do(singletonCtx, func() error {
+					return insertFunc(singletonCtx, dbsqlc.InsertTaskSingletonParams{
+						Args:           task.Args,
+						IdempotencyKey: task.IdempotencyKey,
+					})
 				})
 
+				reporter.RecordRetries(retries, retryTime)
+
 				// Record latency for this task
-				latency := time.Since(startTime)
-				reporter.RecordTask(latency)
+				reporter.RecordTaskStages(startTime.Sub(receivedTime), time.Since(startTime), time.Since(receivedTime), err)
 				reporter.RecordBatch()
 
 				if err != nil {
@@ -139,7 +209,6 @@ outer:
 }
 
 func runContinuousBatch(ctx context.Context) {
-	// Create a reporter
 	reporter := NewReporter()
 
 	insertFunc := insertBatch
@@ -148,101 +217,94 @@ func runContinuousBatch(ctx context.Context) {
 		insertFunc = insertBatchWithAssociatedData
 	}
 
-	writeFunc := func(tasks []dbsqlc.InsertTasksBatchParams) ([]*dbsqlc.Task, error) {
-		reporter.RecordBatch()
-
-		return insertFunc(ctx, tasks)
-	}
-
-	// Create a data generator
-	generator := NewDataGenerator(channelBufferSize)
-	buffer := NewBuffer(ctx, writeFunc)
-
-	// Set up context with timeout
-	timeoutCtx, cancel := context.WithTimeout(ctx, benchmarkDuration)
-	defer cancel()
-
-	generator.Start(ctx)
-
-	var wg sync.WaitGroup
-
-	start := time.Now()
+	runContinuousTasks(ctx, reporter, bufferOptions(reporter, taskParamsSize), func(ctx context.Context, tasks []TaskParams) ([]*dbsqlc.Task, error) {
+		return insertFunc(ctx, batchParams(tasks))
+	})
+}
 
-outer:
-	for {
-		select {
-		case <-timeoutCtx.Done():
-			break outer
-		case task, ok := <-generator.Tasks():
-			if !ok {
-				break outer
-			}
+func runContinuousPing(ctx context.Context) {
+	reporter := NewReporter()
 
-			startTime := time.Now()
+	runContinuousTasks(ctx, reporter, bufferOptions(reporter, taskParamsSize), func(ctx context.Context, tasks []TaskParams) ([]*dbsqlc.Task, error) {
+		if err := pool.Ping(ctx); err != nil {
+			return nil, fmt.Errorf("could not ping database: %w", err)
+		}
 
-			taskWithCh, err := buffer.WriteNoWait(dbsqlc.InsertTasksBatchParams{
-				Args:           task.Args,
-				IdempotencyKey: task.IdempotencyKey,
-			})
+		return inputTasks(tasks), nil
+	})
+}
 
-			if err != nil {
-				log.Printf("could not buffer task: %v", err)
-				return
-			}
+func runContinuousCopyfrom(ctx context.Context) {
+	reporter := NewReporter()
 
-			wg.Add(1)
+	runContinuousTasks(ctx, reporter, bufferOptions(reporter, taskParamsSize), insertCopyFrom)
+}
 
-			go func(task TaskParams) {
-				defer wg.Done()
+func runContinuousUnnest(ctx context.Context) {
+	reporter := NewReporter()
 
-				_, err := taskWithCh.GetResult()
+	if withAssociatedData {
+		runContinuousTasks(ctx, reporter, bufferOptions(reporter, taskParamsSize), insertUnnestWithAssociatedData)
+	} else {
+		runContinuousTasks(ctx, reporter, bufferOptions(reporter, taskParamsSize), insertUnnest)
+	}
+}
 
-				// Record latency for this task
-				latency := time.Since(startTime)
-				reporter.RecordTask(latency)
+func runContinuousValues(ctx context.Context) {
+	reporter := NewReporter()
 
-				if err != nil {
-					log.Printf("could not create task: %v", err)
-					return
-				}
-			}(task)
-		}
+	if withAssociatedData {
+		runContinuousTasks(ctx, reporter, bufferOptions(reporter, taskParamsSize), insertValuesWithAssociatedData)
+	} else {
+		runContinuousTasks(ctx, reporter, bufferOptions(reporter, taskParamsSize), insertValues)
 	}
+}
 
-	// Wait for all workers to finish
-	wg.Wait()
-
-	elapsed := time.Since(start)
+func runContinuousCopyStream(ctx context.Context) {
+	reporter := NewReporter()
 
-	// Print the report
-	reporter.Print(elapsed)
+	runContinuousTasks(ctx, reporter, bufferOptions(reporter, taskParamsSize), insertCopyStream(reporter, copyStreamFormat))
 }
 
-func runContinuousPing(ctx context.Context) {
-	// Create a reporter
-	reporter := NewReporter()
+func runContinuousPipeline(ctx context.Context) {
+	if withAssociatedData {
+		log.Fatal("the pipeline strategy does not support --with-associated-data")
+	}
 
-	writeFunc := func(tasks []dbsqlc.InsertTasksBatchParams) ([]*dbsqlc.InsertTasksBatchParams, error) {
-		reporter.RecordBatch()
+	if pipelineDepth < 1 {
+		log.Fatalf("pipeline depth must be at least 1, got %d", pipelineDepth)
+	}
 
-		err := pool.Ping(ctx)
+	reporter := NewReporter()
+	reporter.SetPipelineDepth(pipelineDepth)
 
-		if err != nil {
-			log.Fatalf("could not ping database: %v", err)
-		}
+	writers := startPipelineWriters(ctx, continuousWritersCount, pipelineDepth, reporter)
+	defer writers.close()
 
-		resTasks := make([]*dbsqlc.InsertTasksBatchParams, 0, len(tasks))
+	// each writer needs up to depth batches flushing at once to fill its pipeline
+	opts := bufferOptions(reporter, taskParamsSize)
+	opts.MaxConcurrentFlushes = continuousWritersCount * pipelineDepth
+	opts.ChannelCapacity = batchSize * continuousWritersCount * pipelineDepth
 
-		for i := 0; i < len(tasks); i++ {
-			resTasks = append(resTasks, &tasks[i])
-		}
+	runContinuousTasks(ctx, reporter, opts, writers.insert)
+}
 
-		return resTasks, nil
+// runContinuousTasks buffers generated tasks and writes them with insertFunc
+func runContinuousTasks(
+	ctx context.Context,
+	reporter *Reporter,
+	opts buffer.Options[TaskParams],
+	insertFunc func(context.Context, []TaskParams) ([]*dbsqlc.Task, error),
+) {
+	// batches are counted by the buffer observer when they're flushed, since a flush
+	// may call writeFunc several times to retry or bisect it
+	writeFunc := func(tasks []TaskParams) ([]*dbsqlc.Task, error) {
+		return insertFunc(ctx, tasks)
 	}
 
 	// Create a data generator
-	generator := NewDataGenerator(channelBufferSize)
-	buffer := NewBuffer(ctx, writeFunc)
+	generator := newGenerator(reporter)
+	buf := newBuffer(ctx, opts, taskParamsKey, writeFunc)
 
 	// Set up context with timeout
 	timeoutCtx, cancel := context.WithTimeout(ctx, benchmarkDuration)
@@ -250,6 +312,17 @@ func runContinuousPing(ctx context.Context) {
 
 	generator.Start(ctx)
 
+	if adaptiveBatching {
+		reporter.TrackBatchLimits(timeoutCtx, time.Second, buf.Limits)
+	}
+
+	if reportInterval > 0 {
+		reporter.StartIntervals(timeoutCtx, reportInterval)
+	}
+
+	stopMetrics := serveMetrics(reporter, buf.QueueDepth)
+	defer stopMetrics()
+
 	var wg sync.WaitGroup
 
 	start := time.Now()
@@ -264,135 +337,113 @@ outer:
 				break outer
 			}
 
-			startTime := time.Now()
+			err := bufferTask(timeoutCtx, buf, reporter, &wg, task.IntendedAt, task)
 
-			taskWithCh, err := buffer.WriteNoWait(dbsqlc.InsertTasksBatchParams{
-				Args:           task.Args,
-				IdempotencyKey: task.IdempotencyKey,
-			})
+			if errors.Is(err, buffer.ErrFull) {
+				// bufferTask has recorded the rejected task as failed
+				continue
+			}
 
 			if err != nil {
 				log.Printf("could not buffer task: %v", err)
-				return
+				break outer
 			}
+		}
+	}
 
-			wg.Add(1)
-
-			go func(task TaskParams) {
-				defer wg.Done()
-
-				_, err := taskWithCh.GetResult()
+	// Stop accepting tasks and flush everything still in the buffer
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer drainCancel()
 
-				// Record latency for this task
-				latency := time.Since(startTime)
-				reporter.RecordTask(latency)
-
-				if err != nil {
-					log.Printf("could not create task: %v", err)
-					return
-				}
-			}(task)
-		}
+	if err := buf.Close(drainCtx); err != nil {
+		log.Printf("could not drain buffer: %v", err)
 	}
 
 	// Wait for all workers to finish
 	wg.Wait()
 
+	reporter.RecordBufferStats(buf.Stats())
+
 	elapsed := time.Since(start)
 
 	// Print the report
 	reporter.Print(elapsed)
 }
 
-func runContinuousCopyfrom(ctx context.Context) {
-	// Create a reporter
-	reporter := NewReporter()
+// newGenerator returns an open-loop generator when --rate is set, and a closed-loop
+// generator otherwise
+func newGenerator(reporter *Reporter) *DataGenerator {
+	if arrivalRate > 0 {
+		reporter.SetTargetRate(arrivalRate, arrivalProcess)
 
-	// note: since copyfrom doesn't return the created tasks, we just return the input
-	writeFunc := func(tasks []dbsqlc.InsertTasksCopyFromParams) ([]*dbsqlc.InsertTasksCopyFromParams, error) {
-		reporter.RecordBatch()
+		return NewOpenLoopDataGenerator(channelBufferSize, arrivalRate, arrivalProcess)
+	}
 
-		n, err := queries.InsertTasksCopyFrom(ctx, pool, tasks)
+	return NewDataGenerator(channelBufferSize)
+}
 
-		if err != nil {
-			log.Fatalf("could not create tasks copyfrom: %v", err)
-		}
+// bufferTask adds a task to the buffer and records its latency once it has been
+// written, either from a result callback or from a goroutine waiting on the result.
+// Latency is measured from intendedAt if it is set, or from now.
+func bufferTask[I, O any](ctx context.Context, buf buffer.Batcher[I, O], reporter *Reporter, wg *sync.WaitGroup, intendedAt time.Time, task I) error {
+	startTime := time.Now()
 
-		if int(n) != len(tasks) {
-			log.Fatalf("could not create tasks copyfrom: expected %d, got %d", len(tasks), n)
-		}
+	if !intendedAt.IsZero() {
+		startTime = intendedAt
+	}
 
-		resTasks := make([]*dbsqlc.InsertTasksCopyFromParams, 0, len(tasks))
+	onResult := func(_ *O, err error) {
+		// Record latency for this task
+		latency := time.Since(startTime)
+		reporter.RecordBufferedTask(latency, err)
 
-		for i := 0; i < len(tasks); i++ {
-			resTasks = append(resTasks, &tasks[i])
+		if err != nil && !errors.Is(err, buffer.ErrShed) {
+			log.Printf("could not create task: %v", err)
 		}
-
-		return resTasks, nil
 	}
 
-	// Create a data generator
-	generator := NewDataGenerator(channelBufferSize)
-	buffer := NewBuffer(ctx, writeFunc)
-
-	// Set up context with timeout
-	timeoutCtx, cancel := context.WithTimeout(ctx, benchmarkDuration)
-	defer cancel()
-
-	generator.Start(ctx)
+	if resultGoroutines {
+		taskWithCh, err := buf.WriteNoWaitContext(ctx, task)
 
-	var wg sync.WaitGroup
+		if err != nil {
+			recordRejection(reporter, startTime, err)
+			return err
+		}
 
-	start := time.Now()
+		wg.Add(1)
 
-outer:
-	for {
-		select {
-		case <-timeoutCtx.Done():
-			break outer
-		case task, ok := <-generator.Tasks():
-			if !ok {
-				break outer
-			}
-
-			startTime := time.Now()
+		go func() {
+			defer wg.Done()
 
-			taskWithCh, err := buffer.WriteNoWait(dbsqlc.InsertTasksCopyFromParams{
-				Args:           task.Args,
-				IdempotencyKey: task.IdempotencyKey,
-			})
+			onResult(taskWithCh.GetResult())
+		}()
 
-			if err != nil {
-				log.Printf("could not buffer task: %v", err)
-				return
-			}
+		return nil
+	}
 
-			wg.Add(1)
+	wg.Add(1)
 
-			go func(task TaskParams) {
-				defer wg.Done()
+	err := buf.WriteWithCallback(ctx, task, func(result *O, err error) {
+		defer wg.Done()
 
-				_, err := taskWithCh.GetResult()
-
-				// Record latency for this task
-				latency := time.Since(startTime)
-				reporter.RecordTask(latency)
+		onResult(result, err)
+	})
 
-				if err != nil {
-					log.Printf("could not create task: %v", err)
-					return
-				}
-			}(task)
-		}
+	if err != nil {
+		wg.Done()
+		recordRejection(reporter, startTime, err)
 	}
 
-	// Wait for all workers to finish
-	wg.Wait()
-
-	elapsed := time.Since(start)
+	return err
+}
 
-	// Print the report
-	reporter.Print(elapsed)
+// recordRejection records a task which the buffer refused because it was full as a
+// failed task. Errors from closing the buffer or canceling the run are not failures
+// of the task.
+func recordRejection(reporter *Reporter, startTime time.Time, err error) {
+	if buffer.IsRejected(err) {
+		reporter.RecordBufferedTask(time.Since(startTime), err)
+	}
 }
 
 func insertSingletonBasic(ctx context.Context, params dbsqlc.InsertTaskSingletonParams) error {
@@ -437,16 +488,25 @@ func insertBatch(ctx context.Context, tasks []dbsqlc.InsertTasksBatchParams) ([]
 
 	resTasks := make([]*dbsqlc.Task, 0, len(tasks))
 
+	var batchErr error
+
 	res.QueryRow(func(i int, t *dbsqlc.Task, err error) {
 		if err != nil {
-			log.Printf("could not create task: %v", err)
+			if batchErr == nil {
+				batchErr = err
+			}
+
 			return
 		}
 		resTasks = append(resTasks, t)
 	})
 
 	if err := res.Close(); err != nil {
-		log.Fatalf("could not create tasks batch: %v", err)
+		return nil, fmt.Errorf("could not create tasks batch: %w", err)
+	}
+
+	if batchErr != nil {
+		return nil, fmt.Errorf("could not create task: %w", batchErr)
 	}
 
 	return resTasks, nil
@@ -465,9 +525,14 @@ func insertBatchWithAssociatedData(ctx context.Context, tasks []dbsqlc.InsertTas
 
 	resTasks := make([]*dbsqlc.Task, 0, len(tasks))
 
+	var batchErr error
+
 	res.QueryRow(func(i int, t *dbsqlc.Task, err error) {
 		if err != nil {
-			log.Printf("could not create task: %v", err)
+			if batchErr == nil {
+				batchErr = err
+			}
+
 			return
 		}
 
@@ -475,7 +540,11 @@ func insertBatchWithAssociatedData(ctx context.Context, tasks []dbsqlc.InsertTas
 	})
 
 	if err := res.Close(); err != nil {
-		log.Fatalf("could not create tasks batch: %v", err)
+		return nil, fmt.Errorf("could not create tasks batch: %w", err)
+	}
+
+	if batchErr != nil {
+		return nil, fmt.Errorf("could not create task: %w", batchErr)
 	}
 
 	args2 := make([]dbsqlc.InsertTaskAssociatedDatasBatchParams, 0, len(tasks))
@@ -490,7 +559,7 @@ func insertBatchWithAssociatedData(ctx context.Context, tasks []dbsqlc.InsertTas
 	res2 := queries.InsertTaskAssociatedDatasBatch(ctx, tx, args2)
 
 	if err := res2.Close(); err != nil {
-		log.Fatalf("could not create task associated data batch: %v", err)
+		return nil, fmt.Errorf("could not create task associated data batch: %w", err)
 	}
 
 	if err := tx.Commit(ctx); err != nil {
@@ -499,3 +568,166 @@ func insertBatchWithAssociatedData(ctx context.Context, tasks []dbsqlc.InsertTas
 
 	return resTasks, err
 }
+
+func batchParams(tasks []TaskParams) []dbsqlc.InsertTasksBatchParams {
+	params := make([]dbsqlc.InsertTasksBatchParams, 0, len(tasks))
+
+	for _, task := range tasks {
+		params = append(params, dbsqlc.InsertTasksBatchParams{
+			Args:           task.Args,
+			IdempotencyKey: task.IdempotencyKey,
+		})
+	}
+
+	return params
+}
+
+// insertCopyFrom writes the tasks with a binary COPY
+func insertCopyFrom(ctx context.Context, tasks []TaskParams) ([]*dbsqlc.Task, error) {
+	params := make([]dbsqlc.InsertTasksCopyFromParams, 0, len(tasks))
+
+	for _, task := range tasks {
+		params = append(params, dbsqlc.InsertTasksCopyFromParams{
+			Args:           task.Args,
+			IdempotencyKey: task.IdempotencyKey,
+		})
+	}
+
+	n, err := queries.InsertTasksCopyFrom(ctx, pool, params)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create tasks copyfrom: %w", err)
+	}
+
+	if int(n) != len(tasks) {
+		return nil, fmt.Errorf("could not create tasks copyfrom: expected %d, got %d", len(tasks), n)
+	}
+
+	return inputTasks(tasks), nil
+}
+
+// inputTasks returns tasks carrying only the input, for strategies such as COPY which
+// don't return the created rows
+func inputTasks(tasks []TaskParams) []*dbsqlc.Task {
+	resTasks := make([]*dbsqlc.Task, 0, len(tasks))
+
+	for _, task := range tasks {
+		resTasks = append(resTasks, &dbsqlc.Task{
+			Args:           task.Args,
+			IdempotencyKey: task.IdempotencyKey,
+		})
+	}
+
+	return resTasks
+}
+
+func unnestParams(tasks []TaskParams) dbsqlc.InsertTasksWithUnnestParams {
+	params := dbsqlc.InsertTasksWithUnnestParams{
+		Args: make([]json.RawMessage, 0, len(tasks)),
+		Keys: make([]string, 0, len(tasks)),
+	}
+
+	for _, task := range tasks {
+		params.Args = append(params.Args, task.Args)
+		params.Keys = append(params.Keys, task.IdempotencyKey.String)
+	}
+
+	return params
+}
+
+// orderByKey returns the created rows in the same order as the input tasks. Postgres
+// doesn't guarantee that RETURNING preserves the order of a multi-row insert, so the
+// rows are matched on their idempotency key.
+func orderByKey(tasks []TaskParams, created []*dbsqlc.Task) ([]*dbsqlc.Task, error) {
+	if len(created) != len(tasks) {
+		return nil, fmt.Errorf("expected %d rows, got %d", len(tasks), len(created))
+	}
+
+	byKey := make(map[string]*dbsqlc.Task, len(created))
+
+	for _, t := range created {
+		byKey[t.IdempotencyKey.String] = t
+	}
+
+	ordered := make([]*dbsqlc.Task, len(tasks))
+
+	for i, task := range tasks {
+		t, ok := byKey[task.IdempotencyKey.String]
+
+		if !ok {
+			return nil, fmt.Errorf("no row returned for key %s", task.IdempotencyKey.String)
+		}
+
+		ordered[i] = t
+	}
+
+	return ordered, nil
+}
+
+func insertUnnest(ctx context.Context, tasks []TaskParams) ([]*dbsqlc.Task, error) {
+	created, err := queries.InsertTasksWithUnnest(ctx, pool, unnestParams(tasks))
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create tasks unnest: %w", err)
+	}
+
+	resTasks, err := orderByKey(tasks, created)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create tasks unnest: %w", err)
+	}
+
+	return resTasks, nil
+}
+
+func insertUnnestWithAssociatedData(ctx context.Context, tasks []TaskParams) ([]*dbsqlc.Task, error) {
+	tx, err := pool.Begin(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	created, err := queries.InsertTasksWithUnnest(ctx, tx, unnestParams(tasks))
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create tasks unnest: %w", err)
+	}
+
+	resTasks, err := orderByKey(tasks, created)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not create tasks unnest: %w", err)
+	}
+
+	if err := insertAssociatedData(ctx, tx, resTasks); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return resTasks, nil
+}
+
+// insertAssociatedData inserts the associated data for tasks which were created in tx
+func insertAssociatedData(ctx context.Context, tx pgx.Tx, tasks []*dbsqlc.Task) error {
+	args := make([]dbsqlc.InsertTaskAssociatedDatasBatchParams, 0, len(tasks))
+
+	for _, task := range tasks {
+		args = append(args, dbsqlc.InsertTaskAssociatedDatasBatchParams{
+			TaskID:   task.ID,
+			ArgsJson: task.Args,
+		})
+	}
+
+	res := queries.InsertTaskAssociatedDatasBatch(ctx, tx, args)
+
+	if err := res.Close(); err != nil {
+		return fmt.Errorf("could not create task associated data batch: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/abelanger5/postgres-fast-inserts/internal/dbsqlc"
+	"github.com/spf13/pflag"
+)
+
+// copyStreamFormat is set by the --format flag of the copystream commands
+var copyStreamFormat = copyFormatCSV
+var copyStreamFile string
+
+// copyFormatFlags holds the --format flag, which is shared by every copystream command
+var copyFormatFlags = newCopyFormatFlags()
+
+func newCopyFormatFlags() *pflag.FlagSet {
+	flags := pflag.NewFlagSet("copystream", pflag.ContinueOnError)
+
+	flags.Var(&copyStreamFormat, "format", "COPY format: csv or text")
+
+	return flags
+}
+
+// copyFormat is the text format used by the copystream strategy. It implements
+// pflag.Value, so that an unknown format is rejected when the flags are parsed.
+type copyFormat string
+
+const (
+	copyFormatCSV  copyFormat = "csv"
+	copyFormatText copyFormat = "text"
+)
+
+func parseCopyFormat(name string) (copyFormat, error) {
+	switch f := copyFormat(name); f {
+	case copyFormatCSV, copyFormatText:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown copy format %q, expected csv or text", name)
+	}
+}
+
+func (f *copyFormat) Set(name string) error {
+	format, err := parseCopyFormat(name)
+
+	if err != nil {
+		return err
+	}
+
+	*f = format
+
+	return nil
+}
+
+func (f *copyFormat) String() string {
+	return string(*f)
+}
+
+func (f *copyFormat) Type() string {
+	return "format"
+}
+
+func (f copyFormat) sql() string {
+	return fmt.Sprintf("COPY tasks (args, idempotency_key) FROM STDIN (FORMAT %s)", f)
+}
+
+// appendRow appends a single encoded row, including the trailing newline, to buf
+func (f copyFormat) appendRow(buf []byte, task TaskParams) []byte {
+	if f == copyFormatText {
+		buf = appendTextField(buf, task.Args)
+		buf = append(buf, '\t')
+
+		if task.IdempotencyKey.Valid {
+			buf = appendTextField(buf, []byte(task.IdempotencyKey.String))
+		} else {
+			buf = append(buf, `\N`...)
+		}
+
+		return append(buf, '\n')
+	}
+
+	buf = appendCSVField(buf, task.Args)
+	buf = append(buf, ',')
+
+	// a NULL key is an unquoted empty field
+	if task.IdempotencyKey.Valid {
+		buf = appendCSVField(buf, []byte(task.IdempotencyKey.String))
+	}
+
+	return append(buf, '\n')
+}
+
+// appendCSVField appends a quoted CSV field. Quoting every field keeps empty strings
+// distinct from NULL, which COPY writes as an unquoted empty field.
+func appendCSVField(buf []byte, field []byte) []byte {
+	buf = append(buf, '"')
+
+	for _, c := range field {
+		if c == '"' {
+			buf = append(buf, '"')
+		}
+
+		buf = append(buf, c)
+	}
+
+	return append(buf, '"')
+}
+
+// appendTextField appends a field in COPY's text format, escaping the characters
+// which would otherwise end the field or row
+func appendTextField(buf []byte, field []byte) []byte {
+	for _, c := range field {
+		switch c {
+		case '\\':
+			buf = append(buf, '\\', '\\')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		default:
+			buf = append(buf, c)
+		}
+	}
+
+	return buf
+}
+
+// copyStats is the client-side cost of a streamed COPY
+type copyStats struct {
+	rows   int64
+	bytes  int64
+	encode time.Duration
+}
+
+// copyFromReader streams already-encoded rows from r to Postgres
+func copyFromReader(ctx context.Context, r io.Reader, format copyFormat) (int64, error) {
+	conn, err := pool.Acquire(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer conn.Release()
+
+	tag, err := conn.Conn().PgConn().CopyFrom(ctx, r, format.sql())
+
+	if err != nil {
+		return 0, fmt.Errorf("could not create tasks copystream: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// copyStream encodes the tasks on the fly and streams them to Postgres, so that rows
+// are sent while later rows are still being encoded
+func copyStream(ctx context.Context, tasks []TaskParams, format copyFormat) (copyStats, error) {
+	pr, pw := io.Pipe()
+
+	stats := copyStats{}
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		w := bufio.NewWriterSize(pw, 64*1024)
+
+		var row []byte
+
+		for _, task := range tasks {
+			start := time.Now()
+			row = format.appendRow(row[:0], task)
+			stats.encode += time.Since(start)
+			stats.bytes += int64(len(row))
+
+			if _, err := w.Write(row); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+
+		pw.CloseWithError(w.Flush())
+	}()
+
+	n, err := copyFromReader(ctx, pr, format)
+
+	// unblock the encoder if the copy ended early
+	pr.CloseWithError(io.ErrClosedPipe)
+	<-done
+
+	if err != nil {
+		return stats, err
+	}
+
+	if int(n) != len(tasks) {
+		return stats, fmt.Errorf("could not create tasks copystream: expected %d, got %d", len(tasks), n)
+	}
+
+	stats.rows = n
+
+	return stats, nil
+}
+
+// insertCopyStream returns an insert function which streams each batch with COPY and
+// records the encoding cost on the reporter, if there is one. COPY doesn't return the
+// created rows, so the returned tasks only carry the input.
+func insertCopyStream(reporter *Reporter, format copyFormat) func(context.Context, []TaskParams) ([]*dbsqlc.Task, error) {
+	return func(ctx context.Context, tasks []TaskParams) ([]*dbsqlc.Task, error) {
+		stats, err := copyStream(ctx, tasks, format)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if reporter != nil {
+			reporter.RecordCopy(stats)
+		}
+
+		return inputTasks(tasks), nil
+	}
+}
+
+// countingReader counts the bytes read from a file which is already encoded
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestAppendTextField(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		want  string
+	}{
+		{name: "plain", field: `{"a":1}`, want: `{"a":1}`},
+		{name: "empty", field: "", want: ""},
+		{name: "quotes are literal", field: `say "hi"`, want: `say "hi"`},
+		{name: "backslash", field: `C:\path`, want: `C:\\path`},
+		{name: "escaped json", field: `{"a":"line\nbreak"}`, want: `{"a":"line\\nbreak"}`},
+		{name: "tab", field: "a\tb", want: `a\tb`},
+		{name: "newline", field: "a\nb", want: `a\nb`},
+		{name: "carriage return", field: "a\r\nb", want: `a\r\nb`},
+		{name: "null marker is escaped", field: `\N`, want: `\\N`},
+		{name: "non-ascii", field: "héllo ✓ 日本", want: "héllo ✓ 日本"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(appendTextField(nil, []byte(tt.field))); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendCSVField(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		want  string
+	}{
+		{name: "plain", field: `{"a":1}`, want: `"{""a"":1}"`},
+		{name: "empty is quoted", field: "", want: `""`},
+		{name: "quotes are doubled", field: `say "hi"`, want: `"say ""hi"""`},
+		{name: "backslash is literal", field: `C:\path`, want: `"C:\path"`},
+		{name: "separators stay inside the quotes", field: "a,b\tc", want: "\"a,b\tc\""},
+		{name: "newlines stay inside the quotes", field: "a\r\nb", want: "\"a\r\nb\""},
+		{name: "non-ascii", field: "héllo ✓ 日本", want: `"héllo ✓ 日本"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(appendCSVField(nil, []byte(tt.field))); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendRowNullKey(t *testing.T) {
+	tests := []struct {
+		format copyFormat
+		key    pgtype.Text
+		want   string
+	}{
+		{format: copyFormatText, key: pgtype.Text{}, want: "{}\t\\N\n"},
+		{format: copyFormatText, key: pgtype.Text{Valid: true}, want: "{}\t\n"},
+		{format: copyFormatCSV, key: pgtype.Text{}, want: "\"{}\",\n"},
+		{format: copyFormatCSV, key: pgtype.Text{Valid: true}, want: "\"{}\",\"\"\n"},
+	}
+
+	for _, tt := range tests {
+		got := string(tt.format.appendRow(nil, TaskParams{Args: json.RawMessage("{}"), IdempotencyKey: tt.key}))
+
+		if got != tt.want {
+			t.Fatalf("%s row with key %+v: got %q, want %q", tt.format, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	tasks := []TaskParams{
+		{Args: json.RawMessage(`{"a":1}`), IdempotencyKey: pgtype.Text{String: "plain", Valid: true}},
+		{Args: json.RawMessage(`{"quote":"say \"hi\""}`), IdempotencyKey: pgtype.Text{String: `"quoted"`, Valid: true}},
+		{Args: json.RawMessage(`{"path":"C:\\dir"}`), IdempotencyKey: pgtype.Text{String: `back\slash`, Valid: true}},
+		{Args: json.RawMessage("{\n\t\"pretty\": true\n}"), IdempotencyKey: pgtype.Text{String: "a,b\tc\nd\re", Valid: true}},
+		{Args: json.RawMessage(`{"name":"héllo ✓ 日本"}`), IdempotencyKey: pgtype.Text{String: "ключ", Valid: true}},
+		{Args: json.RawMessage(`{}`), IdempotencyKey: pgtype.Text{String: "", Valid: true}},
+	}
+
+	var buf []byte
+
+	for _, task := range tasks {
+		buf = copyFormatCSV.appendRow(buf, task)
+	}
+
+	r := csv.NewReader(bytes.NewReader(buf))
+
+	for i, task := range tasks {
+		record, err := r.Read()
+
+		if err != nil {
+			t.Fatalf("row %d: could not read: %v", i, err)
+		}
+
+		if len(record) != 2 || record[0] != string(task.Args) || record[1] != task.IdempotencyKey.String {
+			t.Fatalf("row %d: got %q, want [%q %q]", i, record, task.Args, task.IdempotencyKey.String)
+		}
+	}
+
+	if _, err := r.Read(); err != io.EOF {
+		t.Fatalf("got %v after the last row, want io.EOF", err)
+	}
+}
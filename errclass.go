@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/abelanger5/postgres-fast-inserts/pkg/buffer"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// names for the SQLSTATE codes we expect to see when inserting, see
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+var sqlStateClasses = map[string]string{
+	"23505": "unique_violation",
+	"23502": "not_null_violation",
+	"23503": "foreign_key_violation",
+	"40001": "serialization_failure",
+	"40P01": "deadlock_detected",
+	"53300": "too_many_connections",
+	"55P03": "lock_not_available",
+	"57014": "query_canceled",
+	"57P01": "admin_shutdown",
+	"57P02": "crash_shutdown",
+	"57P03": "cannot_connect_now",
+}
+
+// classifyError returns the SQLSTATE of err, if it has one, and a coarse class used to
+// group failures in the report
+func classifyError(err error) (sqlState string, class string) {
+	var pgErr *pgconn.PgError
+
+	if errors.As(err, &pgErr) {
+		if name, ok := sqlStateClasses[pgErr.Code]; ok {
+			return pgErr.Code, name
+		}
+
+		switch {
+		case len(pgErr.Code) >= 2 && pgErr.Code[:2] == "08":
+			return pgErr.Code, "connection_error"
+		case len(pgErr.Code) >= 2 && pgErr.Code[:2] == "23":
+			return pgErr.Code, "integrity_constraint_violation"
+		default:
+			return pgErr.Code, "database_error"
+		}
+	}
+
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "", "context_canceled"
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, buffer.ErrEnqueueTimeout),
+		errors.Is(err, buffer.ErrMemoryLimit),
+		pgconn.Timeout(err),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return "", "timeout"
+	case errors.Is(err, buffer.ErrShed),
+		errors.Is(err, buffer.ErrFull),
+		errors.Is(err, buffer.ErrDrainTimeout),
+		errors.Is(err, buffer.ErrClosed):
+		return "", "buffer_rejected"
+	case isRetryableError(err):
+		return "", "connection_error"
+	default:
+		return "", "other"
+	}
+}
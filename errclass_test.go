@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/abelanger5/postgres-fast-inserts/pkg/buffer"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantSQLState string
+		wantClass    string
+	}{
+		{
+			name:         "named sqlstate",
+			err:          &pgconn.PgError{Code: "23505"},
+			wantSQLState: "23505",
+			wantClass:    "unique_violation",
+		},
+		{
+			name:         "wrapped named sqlstate",
+			err:          fmt.Errorf("could not create tasks: %w", &pgconn.PgError{Code: "40P01"}),
+			wantSQLState: "40P01",
+			wantClass:    "deadlock_detected",
+		},
+		{
+			name:         "connection exception class",
+			err:          &pgconn.PgError{Code: "08006"},
+			wantSQLState: "08006",
+			wantClass:    "connection_error",
+		},
+		{
+			name:         "integrity constraint class",
+			err:          &pgconn.PgError{Code: "23514"},
+			wantSQLState: "23514",
+			wantClass:    "integrity_constraint_violation",
+		},
+		{
+			name:         "other sqlstate",
+			err:          &pgconn.PgError{Code: "22P02"},
+			wantSQLState: "22P02",
+			wantClass:    "database_error",
+		},
+		{
+			name:      "context canceled",
+			err:       fmt.Errorf("write: %w", context.Canceled),
+			wantClass: "context_canceled",
+		},
+		{
+			name:      "deadline exceeded",
+			err:       context.DeadlineExceeded,
+			wantClass: "timeout",
+		},
+		{
+			name:      "net timeout",
+			err:       &net.OpError{Op: "read", Net: "tcp", Err: &net.DNSError{IsTimeout: true}},
+			wantClass: "timeout",
+		},
+		{
+			name:      "enqueue timeout",
+			err:       buffer.ErrEnqueueTimeout,
+			wantClass: "timeout",
+		},
+		{
+			name:      "buffer full",
+			err:       fmt.Errorf("could not buffer task: %w", buffer.ErrFull),
+			wantClass: "buffer_rejected",
+		},
+		{
+			name:      "buffer closed",
+			err:       buffer.ErrClosed,
+			wantClass: "buffer_rejected",
+		},
+		{
+			name:      "connection refused",
+			err:       &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+			wantClass: "connection_error",
+		},
+		{
+			name:      "unexpected eof",
+			err:       fmt.Errorf("receive message: %w", io.ErrUnexpectedEOF),
+			wantClass: "connection_error",
+		},
+		{
+			name:      "unknown error",
+			err:       errors.New("something else"),
+			wantClass: "other",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sqlState, class := classifyError(tt.err)
+
+			if sqlState != tt.wantSQLState || class != tt.wantClass {
+				t.Fatalf("got (%q, %q), want (%q, %q)", sqlState, class, tt.wantSQLState, tt.wantClass)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/cobra"
+)
+
+// execModeNames lists the supported --exec-mode values, in the order matrix runs them
+var execModeNames = []string{
+	"cache_statement",
+	"cache_describe",
+	"describe_exec",
+	"exec",
+	"simple_protocol",
+}
+
+var execModes = map[string]pgx.QueryExecMode{
+	"cache_statement": pgx.QueryExecModeCacheStatement,
+	"cache_describe":  pgx.QueryExecModeCacheDescribe,
+	"describe_exec":   pgx.QueryExecModeDescribeExec,
+	"exec":            pgx.QueryExecModeExec,
+	"simple_protocol": pgx.QueryExecModeSimpleProtocol,
+}
+
+func parseExecMode(name string) (pgx.QueryExecMode, error) {
+	mode, ok := execModes[name]
+
+	if !ok {
+		return 0, fmt.Errorf("unknown exec mode %q, expected one of %s", name, strings.Join(execModeNames, ", "))
+	}
+
+	return mode, nil
+}
+
+// matrixCmd runs a command once per query execution mode. Each run is a separate
+// process so that it gets a fresh pool and reporter.
+var matrixCmd = &cobra.Command{
+	Use:                "matrix <command> [flags]",
+	Short:              "matrix runs a command under every query execution mode and compares throughput and latency.",
+	Example:            "  inserts matrix continuous batch --duration 10s --batch-size 500",
+	DisableFlagParsing: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+			return cmd.Help()
+		}
+
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+
+		return runMatrix(args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(matrixCmd)
+}
+
+// MatrixResult is the report for a single execution mode
+type MatrixResult struct {
+	ExecMode string      `json:"execMode"`
+	Report   *ReportData `json:"report,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+func runMatrix(args []string) error {
+	exe, err := os.Executable()
+
+	if err != nil {
+		return err
+	}
+
+	jsonResults := false
+
+	for _, arg := range args {
+		if arg == "--json" || arg == "-j" {
+			jsonResults = true
+		}
+	}
+
+	results := make([]MatrixResult, 0, len(execModeNames))
+	failed := 0
+
+	for _, mode := range execModeNames {
+		fmt.Fprintf(os.Stderr, "Running %s with exec mode %s\n", strings.Join(args, " "), mode)
+
+		var stdout bytes.Buffer
+
+		// later flags take precedence, so these override any given in args
+		run := exec.Command(exe, append(append([]string{}, args...), "--exec-mode", mode, "--json")...)
+		run.Stdout = &stdout
+		run.Stderr = os.Stderr
+
+		result := MatrixResult{ExecMode: mode}
+
+		if err := run.Run(); err != nil {
+			result.Error = err.Error()
+		} else {
+			var report ReportData
+
+			if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+				result.Error = fmt.Sprintf("command did not print a JSON report: %v", err)
+			} else {
+				result.Report = &report
+			}
+		}
+
+		if result.Error != "" {
+			failed++
+		}
+
+		results = append(results, result)
+	}
+
+	if jsonResults {
+		jsonBytes, err := json.MarshalIndent(results, "", "  ")
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(jsonBytes))
+	} else {
+		printMatrix(results)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d exec modes failed", failed, len(results))
+	}
+
+	return nil
+}
+
+func printMatrix(results []MatrixResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "exec mode\trows\tfailed\trows/second\tp50\tp90\tp99\tmax")
+
+	for _, result := range results {
+		if result.Report == nil {
+			fmt.Fprintf(w, "%s\terror: %s\n", result.ExecMode, result.Error)
+			continue
+		}
+
+		r := result.Report
+
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.2f\t%s\t%s\t%s\t%s\n",
+			result.ExecMode, r.TaskCount, r.FailedCount, r.Throughput,
+			r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.Max)
+	}
+
+	w.Flush()
+}
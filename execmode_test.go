@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/abelanger5/postgres-fast-inserts/internal/dbsqlc"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// encodeParams encodes args the way pgx does for the exec mode, and returns each
+// value with its format code
+func encodeParams(t *testing.T, m *pgtype.Map, mode pgx.QueryExecMode, oids []uint32, args []any) ([][]byte, []int16) {
+	t.Helper()
+
+	switch mode {
+	case pgx.QueryExecModeSimpleProtocol:
+		values := make([][]byte, len(args))
+		formats := make([]int16, len(args))
+
+		for i, arg := range args {
+			buf, err := m.Encode(0, pgtype.TextFormatCode, arg, nil)
+
+			if err != nil {
+				t.Fatalf("could not encode arg %d: %v", i, err)
+			}
+
+			values[i] = buf
+		}
+
+		return values, formats
+	case pgx.QueryExecModeExec:
+		var eqb pgx.ExtendedQueryBuilder
+
+		if err := eqb.Build(m, nil, args); err != nil {
+			t.Fatalf("could not encode args: %v", err)
+		}
+
+		return eqb.ParamValues, eqb.ParamFormats
+	default:
+		var eqb pgx.ExtendedQueryBuilder
+
+		if err := eqb.Build(m, &pgconn.StatementDescription{ParamOIDs: oids}, args); err != nil {
+			t.Fatalf("could not encode args: %v", err)
+		}
+
+		return eqb.ParamValues, eqb.ParamFormats
+	}
+}
+
+// TestArgsEncodeAsJSON checks that task args reach the server as JSON under every exec
+// mode. The exec and simple_protocol modes don't send parameter types, so the server
+// parses the text encoding of the Go type as jsonb.
+func TestArgsEncodeAsJSON(t *testing.T) {
+	payload := json.RawMessage(`{"name":"task","tags":["a","b"]}`)
+
+	single := dbsqlc.InsertTaskSingletonParams{Args: payload, IdempotencyKey: pgtype.Text{String: "key", Valid: true}}
+	unnest := unnestParams([]TaskParams{{Args: payload}, {Args: payload}})
+
+	for _, name := range execModeNames {
+		mode := execModes[name]
+
+		t.Run(name, func(t *testing.T) {
+			m := pgtype.NewMap()
+
+			values, formats := encodeParams(t, m, mode,
+				[]uint32{pgtype.JSONBOID, pgtype.TextOID},
+				[]any{single.Args, single.IdempotencyKey},
+			)
+
+			var got json.RawMessage
+
+			if err := m.Scan(pgtype.JSONBOID, formats[0], values[0], &got); err != nil {
+				t.Fatalf("args are not valid jsonb: %v (%q)", err, values[0])
+			}
+
+			if string(got) != string(payload) {
+				t.Fatalf("got args %s, want %s", got, payload)
+			}
+
+			values, formats = encodeParams(t, m, mode,
+				[]uint32{pgtype.JSONBArrayOID, pgtype.TextArrayOID},
+				[]any{unnest.Args, unnest.Keys},
+			)
+
+			var gotArray []json.RawMessage
+
+			if err := m.Scan(pgtype.JSONBArrayOID, formats[0], values[0], &gotArray); err != nil {
+				t.Fatalf("unnest args are not a valid jsonb array: %v (%q)", err, values[0])
+			}
+
+			if len(gotArray) != len(unnest.Args) {
+				t.Fatalf("got %d unnest args, want %d", len(gotArray), len(unnest.Args))
+			}
+
+			for i := range gotArray {
+				if string(gotArray[i]) != string(payload) {
+					t.Fatalf("unnest arg %d: got %s, want %s", i, gotArray[i], payload)
+				}
+			}
+		})
+	}
+}
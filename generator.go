@@ -2,19 +2,100 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type TaskParams struct {
-	Args           []byte
+	Args           json.RawMessage
 	IdempotencyKey pgtype.Text
+
+	// Key is a synthetic tenant id which the partitioned buffer routes tasks by. It
+	// is empty when --keys is 0.
+	Key string
+
+	// IntendedAt is the time the task was scheduled to be sent by an open-loop
+	// generator, and is zero otherwise
+	IntendedAt time.Time
+}
+
+// ArrivalProcess determines the gaps between tasks in open-loop mode
+type ArrivalProcess int
+
+const (
+	// ArrivalConstant sends tasks at evenly spaced intervals
+	ArrivalConstant ArrivalProcess = iota
+
+	// ArrivalPoisson sends tasks with exponentially distributed gaps, which models
+	// many independent clients
+	ArrivalPoisson
+)
+
+// ParseArrivalProcess parses an arrival process name: "constant" or "poisson"
+func ParseArrivalProcess(name string) (ArrivalProcess, error) {
+	switch name {
+	case "constant":
+		return ArrivalConstant, nil
+	case "poisson":
+		return ArrivalPoisson, nil
+	default:
+		return 0, fmt.Errorf("unknown arrival process %q, expected constant or poisson", name)
+	}
+}
+
+func (a ArrivalProcess) String() string {
+	if a == ArrivalPoisson {
+		return "poisson"
+	}
+
+	return "constant"
+}
+
+// parseRate parses a rate such as "50000/s", "50/ms" or "50000" (per second) and
+// returns it in tasks per second
+func parseRate(s string) (float64, error) {
+	countStr, unit, hasUnit := strings.Cut(s, "/")
+
+	count, err := strconv.ParseFloat(countStr, 64)
+
+	if err != nil || count <= 0 || math.IsNaN(count) || math.IsInf(count, 0) {
+		return 0, fmt.Errorf("invalid rate %q: expected a positive number of tasks, e.g. 50000/s", s)
+	}
+
+	if !hasUnit {
+		return count, nil
+	}
+
+	// accept both "/s" and "/100ms"
+	period, err := time.ParseDuration(unit)
+
+	if err != nil {
+		period, err = time.ParseDuration("1" + unit)
+	}
+
+	if err != nil || period <= 0 {
+		return 0, fmt.Errorf("invalid rate %q: could not parse period %q", s, unit)
+	}
+
+	return count / period.Seconds(), nil
 }
 
 // DataGenerator is a structure that emits data continuously
 type DataGenerator struct {
 	taskChan chan TaskParams
+
+	// rate is the target number of tasks per second in open-loop mode, or 0 to emit
+	// tasks as fast as they are consumed
+	rate    float64
+	arrival ArrivalProcess
 }
 
 // NewDataGenerator creates a new data generator
@@ -24,8 +105,40 @@ func NewDataGenerator(bufferSize int) *DataGenerator {
 	}
 }
 
+// NewOpenLoopDataGenerator creates a data generator which schedules tasks at a fixed
+// rate regardless of how fast they are consumed. Each task carries the time it was
+// scheduled for, so latency can be measured without coordinated omission.
+func NewOpenLoopDataGenerator(bufferSize int, rate float64, arrival ArrivalProcess) *DataGenerator {
+	return &DataGenerator{
+		taskChan: make(chan TaskParams, bufferSize),
+		rate:     rate,
+		arrival:  arrival,
+	}
+}
+
+func newTaskParams() TaskParams {
+	task := TaskParams{
+		Args: generateJSONPayload(),
+		IdempotencyKey: pgtype.Text{
+			String: uuid.NewString(),
+			Valid:  true,
+		},
+	}
+
+	if partitionKeys > 0 {
+		task.Key = "tenant-" + strconv.Itoa(rand.Intn(partitionKeys))
+	}
+
+	return task
+}
+
 // Start begins the data generation process
 func (g *DataGenerator) Start(ctx context.Context) {
+	if g.rate > 0 {
+		go g.runOpenLoop(ctx)
+		return
+	}
+
 	go func() {
 		for {
 			select {
@@ -33,20 +146,62 @@ func (g *DataGenerator) Start(ctx context.Context) {
 				close(g.taskChan)
 				return
 			default:
-				payload := generateJSONPayload()
-				task := TaskParams{
-					Args: payload,
-					IdempotencyKey: pgtype.Text{
-						String: uuid.NewString(),
-						Valid:  true,
-					},
-				}
-				g.taskChan <- task
+				g.taskChan <- newTaskParams()
 			}
 		}
 	}()
 }
 
+// interArrival returns the gap until the next scheduled task
+func (g *DataGenerator) interArrival() time.Duration {
+	mean := float64(time.Second) / g.rate
+
+	if g.arrival == ArrivalPoisson {
+		return time.Duration(rand.ExpFloat64() * mean)
+	}
+
+	return time.Duration(mean)
+}
+
+// runOpenLoop emits tasks on a schedule which does not depend on when earlier tasks
+// were consumed. If the consumer falls behind, tasks are sent as soon as possible
+// with their original scheduled time, so the delay is counted in their latency.
+func (g *DataGenerator) runOpenLoop(ctx context.Context) {
+	defer close(g.taskChan)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	<-timer.C
+
+	next := time.Now()
+
+	for {
+		// at high rates several tasks are due by the time the timer fires, and are
+		// sent without waiting
+		if wait := time.Until(next); wait > 0 {
+			timer.Reset(wait)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+		}
+
+		task := newTaskParams()
+		task.IntendedAt = next
+
+		select {
+		case <-ctx.Done():
+			return
+		case g.taskChan <- task:
+		}
+
+		next = next.Add(g.interArrival())
+	}
+}
+
 // Tasks returns the channel for tasks
 func (g *DataGenerator) Tasks() <-chan TaskParams {
 	return g.taskChan
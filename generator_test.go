@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		rate    string
+		want    float64
+		wantErr bool
+	}{
+		{rate: "50000/s", want: 50000},
+		{rate: "100/ms", want: 100000},
+		{rate: "50000", want: 50000},
+		{rate: "0.5", want: 0.5},
+		{rate: "1e3/s", want: 1000},
+		{rate: "600/m", want: 10},
+		{rate: "5/100ms", want: 50},
+		{rate: "1/2s", want: 0.5},
+		{rate: "0", wantErr: true},
+		{rate: "0/s", wantErr: true},
+		{rate: "-10/s", wantErr: true},
+		{rate: "10/-1s", wantErr: true},
+		{rate: "10/0s", wantErr: true},
+		{rate: "", wantErr: true},
+		{rate: "/s", wantErr: true},
+		{rate: "fast", wantErr: true},
+		{rate: "10/", wantErr: true},
+		{rate: "10/fortnight", wantErr: true},
+		{rate: "10/s/s", wantErr: true},
+		{rate: "NaN", wantErr: true},
+		{rate: "Inf/s", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rate, func(t *testing.T) {
+			got, err := parseRate(tt.rate)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if math.Abs(got-tt.want) > 1e-9*tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseArrivalProcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    ArrivalProcess
+		wantErr bool
+	}{
+		{name: "constant", want: ArrivalConstant},
+		{name: "poisson", want: ArrivalPoisson},
+		{name: "Poisson", wantErr: true},
+		{name: "", wantErr: true},
+		{name: "uniform", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseArrivalProcess(tt.name)
+
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%q: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+
+		if err == nil && (got != tt.want || got.String() != tt.name) {
+			t.Fatalf("%q: got %s", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// RunRecord is a single entry in the run history file
+type RunRecord struct {
+	ID          string            `json:"id"`
+	Time        time.Time         `json:"time"`
+	Command     string            `json:"command"`
+	Args        []string          `json:"args"`
+	Flags       map[string]string `json:"flags"`
+	GitRevision string            `json:"gitRevision,omitempty"`
+	GoVersion   string            `json:"goVersion"`
+	Host        HostInfo          `json:"host"`
+	Postgres    *PostgresInfo     `json:"postgres,omitempty"`
+	Report      ReportData        `json:"report"`
+}
+
+// HostInfo describes the machine which ran the benchmark
+type HostInfo struct {
+	Hostname    string `json:"hostname"`
+	OS          string `json:"os"`
+	Arch        string `json:"arch"`
+	CPUs        int    `json:"cpus"`
+	CPUModel    string `json:"cpuModel,omitempty"`
+	MemoryBytes int64  `json:"memoryBytes,omitempty"`
+}
+
+// PostgresInfo describes the server the benchmark ran against
+type PostgresInfo struct {
+	Version  string            `json:"version"`
+	Settings map[string]string `json:"settings"`
+}
+
+// recordedSettings are the server settings which most affect insert performance
+var recordedSettings = []string{
+	"shared_buffers",
+	"synchronous_commit",
+	"fsync",
+	"full_page_writes",
+	"wal_level",
+	"wal_compression",
+	"wal_buffers",
+	"max_wal_size",
+	"checkpoint_timeout",
+	"commit_delay",
+	"max_connections",
+	"work_mem",
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "history lists and exports runs recorded with --history.",
+}
+
+var historyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list prints a summary of past runs.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+
+		records, err := filteredHistory()
+
+		if err != nil {
+			return err
+		}
+
+		printHistory(records)
+
+		return nil
+	},
+}
+
+var historyExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export writes past runs to stdout as JSON or CSV.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+
+		records, err := filteredHistory()
+
+		if err != nil {
+			return err
+		}
+
+		switch historyFormat {
+		case "json":
+			return json.NewEncoder(os.Stdout).Encode(records)
+		case "csv":
+			return exportHistoryCSV(records)
+		default:
+			return fmt.Errorf("unknown export format %q, expected json or csv", historyFormat)
+		}
+	},
+}
+
+var historyFile string
+var historyCommand string
+var historySince string
+var historyRevision string
+var historyLimit int
+var historyFormat string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(
+		&historyFile,
+		"history",
+		"",
+		"append each run's report and metadata to this JSON lines file",
+	)
+
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.AddCommand(historyListCmd)
+	historyCmd.AddCommand(historyExportCmd)
+
+	historyCmd.PersistentFlags().StringVar(
+		&historyCommand,
+		"command",
+		"",
+		"only include runs whose command contains this string, e.g. \"continuous batch\"",
+	)
+
+	historyCmd.PersistentFlags().StringVar(
+		&historySince,
+		"since",
+		"",
+		"only include runs after this time, as a duration (24h) or date (2006-01-02)",
+	)
+
+	historyCmd.PersistentFlags().StringVar(
+		&historyRevision,
+		"rev",
+		"",
+		"only include runs built from a git revision with this prefix",
+	)
+
+	historyCmd.PersistentFlags().IntVar(
+		&historyLimit,
+		"limit",
+		0,
+		"only include the most recent n runs (0 for all)",
+	)
+
+	historyExportCmd.Flags().StringVar(
+		&historyFormat,
+		"format",
+		"json",
+		"export format: json or csv",
+	)
+}
+
+// appendHistory records the report for the current command to the history file
+func appendHistory(path string, report ReportData) error {
+	record := RunRecord{
+		ID:          uuid.NewString(),
+		Time:        time.Now().UTC(),
+		Args:        os.Args[1:],
+		Flags:       map[string]string{},
+		GitRevision: gitRevision(),
+		GoVersion:   runtime.Version(),
+		Host:        hostInfo(),
+		Postgres:    postgresInfo(context.Background()),
+		Report:      report,
+	}
+
+	if cmd, _, err := rootCmd.Find(os.Args[1:]); err == nil {
+		record.Command = executedCommandName()
+
+		cmd.Flags().VisitAll(func(f *pflag.Flag) {
+			record.Flags[f.Name] = f.Value.String()
+		})
+	}
+
+	data, err := json.Marshal(record)
+
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// executedCommandName returns the path of the command being run without the root
+// command, e.g. "continuous batch"
+func executedCommandName() string {
+	cmd, _, err := rootCmd.Find(os.Args[1:])
+
+	if err != nil || cmd == rootCmd {
+		return rootCmd.Name()
+	}
+
+	return strings.TrimPrefix(cmd.CommandPath(), rootCmd.Name()+" ")
+}
+
+func gitRevision() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+
+	// fall back to the working directory, e.g. when using go run
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func hostInfo() HostInfo {
+	hostname, _ := os.Hostname()
+
+	info := HostInfo{
+		Hostname: hostname,
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		CPUs:     runtime.NumCPU(),
+	}
+
+	// the CPU model and memory are only read on Linux
+	if v, ok := procField("/proc/cpuinfo", "model name"); ok {
+		info.CPUModel = v
+	}
+
+	if v, ok := procField("/proc/meminfo", "MemTotal"); ok {
+		kb, err := strconv.ParseInt(strings.TrimSuffix(v, " kB"), 10, 64)
+
+		if err == nil {
+			info.MemoryBytes = kb * 1024
+		}
+	}
+
+	return info
+}
+
+// procField returns the value of the first "key: value" line in a /proc file
+func procField(path, key string) (string, bool) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return "", false
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), ":")
+
+		if ok && strings.TrimSpace(k) == key {
+			return strings.TrimSpace(v), true
+		}
+	}
+
+	return "", false
+}
+
+func postgresInfo(ctx context.Context) *PostgresInfo {
+	info := &PostgresInfo{
+		Settings: map[string]string{},
+	}
+
+	if err := pool.QueryRow(ctx, "SHOW server_version").Scan(&info.Version); err != nil {
+		return nil
+	}
+
+	rows, err := pool.Query(ctx, "SELECT name, setting || coalesce(unit, '') FROM pg_settings WHERE name = ANY($1)", recordedSettings)
+
+	if err != nil {
+		return info
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, setting string
+
+		if err := rows.Scan(&name, &setting); err != nil {
+			break
+		}
+
+		info.Settings[name] = setting
+	}
+
+	return info
+}
+
+func readHistory(path string) ([]RunRecord, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	records := []RunRecord{}
+	scanner := bufio.NewScanner(f)
+
+	// reports with histograms and interval samples can exceed the default line limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var record RunRecord
+
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.DateOnly, s)
+}
+
+func filteredHistory() ([]RunRecord, error) {
+	if historyFile == "" {
+		return nil, fmt.Errorf("--history must be set to the history file")
+	}
+
+	records, err := readHistory(historyFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var since time.Time
+
+	if historySince != "" {
+		since, err = parseSince(historySince)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not parse --since: %w", err)
+		}
+	}
+
+	filtered := []RunRecord{}
+
+	for _, record := range records {
+		if historyCommand != "" && !strings.Contains(record.Command, historyCommand) {
+			continue
+		}
+
+		if !since.IsZero() && record.Time.Before(since) {
+			continue
+		}
+
+		if historyRevision != "" && !strings.HasPrefix(record.GitRevision, historyRevision) {
+			continue
+		}
+
+		filtered = append(filtered, record)
+	}
+
+	if historyLimit > 0 && len(filtered) > historyLimit {
+		filtered = filtered[len(filtered)-historyLimit:]
+	}
+
+	return filtered, nil
+}
+
+// shortID truncates a run id or git revision for display
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+
+	return id
+}
+
+func printHistory(records []RunRecord) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "id\ttime\tcommand\trev\trows\tfailed\tthroughput\tp99")
+
+	for _, record := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%.2f\t%s\n",
+			shortID(record.ID),
+			record.Time.Local().Format(time.DateTime),
+			record.Command,
+			shortID(record.GitRevision),
+			record.Report.TaskCount,
+			record.Report.FailedCount,
+			record.Report.Throughput,
+			record.Report.Latency.P99,
+		)
+	}
+
+	w.Flush()
+}
+
+func exportHistoryCSV(records []RunRecord) error {
+	w := csv.NewWriter(os.Stdout)
+
+	header := []string{
+		"id", "time", "command", "args", "git_revision", "go_version", "cpus", "postgres_version",
+		"rows", "failed", "total_time", "throughput", "p50", "p90", "p99", "max", "batches", "avg_batch_size",
+	}
+
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		pgVersion := ""
+
+		if record.Postgres != nil {
+			pgVersion = record.Postgres.Version
+		}
+
+		row := []string{
+			record.ID,
+			record.Time.Format(time.RFC3339),
+			record.Command,
+			strings.Join(record.Args, " "),
+			record.GitRevision,
+			record.GoVersion,
+			strconv.Itoa(record.Host.CPUs),
+			pgVersion,
+			strconv.Itoa(record.Report.TaskCount),
+			strconv.Itoa(record.Report.FailedCount),
+			record.Report.TotalTime,
+			strconv.FormatFloat(record.Report.Throughput, 'f', 2, 64),
+			record.Report.Latency.P50,
+			record.Report.Latency.P90,
+			record.Report.Latency.P99,
+			record.Report.Latency.Max,
+			strconv.Itoa(record.Report.NumBatches),
+			strconv.Itoa(record.Report.AvgBatchSize),
+		}
+
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+
+	return w.Error()
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestShortID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{id: "", want: ""},
+		{id: "abc", want: "abc"},
+		{id: "12345678", want: "12345678"},
+		{id: "0f5ef54c-1d2e-4f3a-9b8c-7d6e5f4a3b2c", want: "0f5ef54c"},
+	}
+
+	for _, tt := range tests {
+		if got := shortID(tt.id); got != tt.want {
+			t.Errorf("shortID(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abelanger5/postgres-fast-inserts/internal/histogram"
+)
+
+var htmlFile string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(
+		&htmlFile,
+		"html",
+		"",
+		"write a self-contained HTML report with charts to this file",
+	)
+}
+
+// namedReport is a report with the label used for it in charts and tables
+type namedReport struct {
+	Name   string
+	Report ReportData
+}
+
+// chartPoint is a single point on a chart
+type chartPoint struct {
+	X, Y float64
+}
+
+// chartSeries is a named line on a chart
+type chartSeries struct {
+	Name   string
+	Points []chartPoint
+}
+
+// lineChart renders one or more series as an inline SVG line chart
+type lineChart struct {
+	Title  string
+	XLabel string
+	YLabel string
+	Series []chartSeries
+
+	// FormatX formats the tick labels on the x axis, and defaults to formatNumber
+	FormatX func(float64) string
+}
+
+// barChart renders labelled values as an inline SVG bar chart
+type barChart struct {
+	Title  string
+	XLabel string
+	YLabel string
+	Labels []string
+	Values []float64
+}
+
+const (
+	chartWidth  = 760
+	chartHeight = 320
+
+	marginLeft   = 80
+	marginRight  = 20
+	marginTop    = 36
+	marginBottom = 50
+
+	chartTicks = 5
+)
+
+var chartColors = []string{"#2563eb", "#dc2626", "#16a34a", "#9333ea", "#ea580c", "#0891b2", "#4b5563", "#ca8a04"}
+
+func formatNumber(v float64) string {
+	switch {
+	case math.Abs(v) >= 1e6:
+		return strconv.FormatFloat(v/1e6, 'f', 1, 64) + "M"
+	case math.Abs(v) >= 1e4:
+		return strconv.FormatFloat(v/1e3, 'f', 1, 64) + "k"
+	case v == math.Trunc(v):
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	default:
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	}
+}
+
+func writeChartFrame(b *strings.Builder, title, xLabel, yLabel string, maxY float64) {
+	plotHeight := float64(chartHeight - marginTop - marginBottom)
+
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" class="chart">`, chartWidth, chartHeight)
+	fmt.Fprintf(b, `<text x="%d" y="20" class="title">%s</text>`, chartWidth/2, template.HTMLEscapeString(title))
+
+	for i := 0; i <= chartTicks; i++ {
+		y := float64(marginTop) + plotHeight - plotHeight*float64(i)/chartTicks
+
+		fmt.Fprintf(b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" class="grid"/>`, marginLeft, y, chartWidth-marginRight, y)
+		fmt.Fprintf(b, `<text x="%d" y="%.1f" class="ytick">%s</text>`, marginLeft-6, y+4, formatNumber(maxY*float64(i)/chartTicks))
+	}
+
+	fmt.Fprintf(b, `<text x="%d" y="%d" class="label">%s</text>`, (chartWidth+marginLeft)/2, chartHeight-8, template.HTMLEscapeString(xLabel))
+	fmt.Fprintf(b, `<text x="16" y="%d" class="label" transform="rotate(-90 16 %d)">%s</text>`,
+		(chartHeight-marginBottom+marginTop)/2, (chartHeight-marginBottom+marginTop)/2, template.HTMLEscapeString(yLabel))
+}
+
+func (c lineChart) svg() template.HTML {
+	formatX := c.FormatX
+
+	if formatX == nil {
+		formatX = formatNumber
+	}
+
+	minX, maxX, maxY := math.Inf(1), math.Inf(-1), 0.0
+
+	series := []chartSeries{}
+
+	for _, s := range c.Series {
+		if len(s.Points) > 0 {
+			series = append(series, s)
+		}
+	}
+
+	for _, s := range series {
+		for _, p := range s.Points {
+			minX = math.Min(minX, p.X)
+			maxX = math.Max(maxX, p.X)
+			maxY = math.Max(maxY, p.Y)
+		}
+	}
+
+	if math.IsInf(minX, 1) {
+		return ""
+	}
+
+	if maxX == minX {
+		maxX = minX + 1
+	}
+
+	if maxY == 0 {
+		maxY = 1
+	}
+
+	maxY *= 1.05
+
+	plotWidth := float64(chartWidth - marginLeft - marginRight)
+	plotHeight := float64(chartHeight - marginTop - marginBottom)
+
+	scaleX := func(x float64) float64 {
+		return float64(marginLeft) + (x-minX)/(maxX-minX)*plotWidth
+	}
+
+	scaleY := func(y float64) float64 {
+		return float64(marginTop) + plotHeight - y/maxY*plotHeight
+	}
+
+	var b strings.Builder
+
+	writeChartFrame(&b, c.Title, c.XLabel, c.YLabel, maxY)
+
+	for i := 0; i <= chartTicks; i++ {
+		x := minX + (maxX-minX)*float64(i)/chartTicks
+
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" class="xtick">%s</text>`, scaleX(x), chartHeight-marginBottom+16, formatX(x))
+	}
+
+	for i, s := range series {
+		color := chartColors[i%len(chartColors)]
+		points := make([]string, len(s.Points))
+
+		for j, p := range s.Points {
+			points[j] = fmt.Sprintf("%.1f,%.1f", scaleX(p.X), scaleY(p.Y))
+		}
+
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`, strings.Join(points, " "), color)
+
+		// only mark individual points when they can be told apart
+		if len(s.Points) <= 50 {
+			for _, p := range s.Points {
+				fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"><title>%s: %s, %s</title></circle>`,
+					scaleX(p.X), scaleY(p.Y), color, template.HTMLEscapeString(s.Name), formatX(p.X), formatNumber(p.Y))
+			}
+		}
+
+		if len(series) > 1 {
+			y := marginTop + 4 + 16*i
+
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`, marginLeft+10, y, color)
+			fmt.Fprintf(&b, `<text x="%d" y="%d" class="legend">%s</text>`, marginLeft+26, y+9, template.HTMLEscapeString(s.Name))
+		}
+	}
+
+	b.WriteString("</svg>")
+
+	return template.HTML(b.String())
+}
+
+func (c barChart) svg() template.HTML {
+	if len(c.Values) == 0 {
+		return ""
+	}
+
+	maxY := 0.0
+
+	for _, v := range c.Values {
+		maxY = math.Max(maxY, v)
+	}
+
+	if maxY == 0 {
+		maxY = 1
+	}
+
+	maxY *= 1.05
+
+	plotWidth := float64(chartWidth - marginLeft - marginRight)
+	plotHeight := float64(chartHeight - marginTop - marginBottom)
+	slot := plotWidth / float64(len(c.Values))
+
+	var b strings.Builder
+
+	writeChartFrame(&b, c.Title, c.XLabel, c.YLabel, maxY)
+
+	// label every bar when there are few, otherwise about ten of them
+	labelEvery := max(1, len(c.Values)/10)
+
+	for i, v := range c.Values {
+		height := v / maxY * plotHeight
+		x := float64(marginLeft) + slot*float64(i)
+
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %s</title></rect>`,
+			x+slot*0.1, float64(marginTop)+plotHeight-height, slot*0.8, height, chartColors[0],
+			template.HTMLEscapeString(c.Labels[i]), formatNumber(v))
+
+		if i%labelEvery == 0 {
+			fmt.Fprintf(&b, `<text x="%.1f" y="%d" class="xtick">%s</text>`,
+				x+slot/2, chartHeight-marginBottom+16, template.HTMLEscapeString(c.Labels[i]))
+		}
+	}
+
+	b.WriteString("</svg>")
+
+	return template.HTML(b.String())
+}
+
+// throughputSeries returns the completed tasks per second of a run
+func throughputSeries(r namedReport) chartSeries {
+	s := chartSeries{Name: r.Name}
+
+	for i, n := range r.Report.PerSecond {
+		s.Points = append(s.Points, chartPoint{X: float64(i + 1), Y: float64(n)})
+	}
+
+	return s
+}
+
+// latencyPercentiles are plotted on the latency curve. The x axis is the number of
+// nines, so that the tail is as visible as the median.
+var latencyPercentiles = []float64{0, 50, 75, 90, 95, 99, 99.5, 99.9, 99.95, 99.99, 99.999}
+
+func nines(p float64) float64 {
+	return -math.Log10(1 - p/100)
+}
+
+func formatNines(x float64) string {
+	return "p" + strconv.FormatFloat(100*(1-math.Pow(10, -x)), 'g', 6, 64)
+}
+
+// bucketPercentile returns the upper bound of the histogram bucket containing the
+// p-th percentile
+func bucketPercentile(buckets []histogram.Bucket, p float64) (time.Duration, bool) {
+	var total int64
+
+	for _, b := range buckets {
+		total += b.Count
+	}
+
+	if total == 0 {
+		return 0, false
+	}
+
+	rank := int64(math.Ceil(p / 100 * float64(total)))
+	rank = max(rank, 1)
+
+	var seen int64
+
+	for _, b := range buckets {
+		seen += b.Count
+
+		if seen >= rank {
+			return b.UpperBound, true
+		}
+	}
+
+	return buckets[len(buckets)-1].UpperBound, true
+}
+
+// latencySeries returns the latency percentile curve in milliseconds
+func latencySeries(name string, l LatencyReport) chartSeries {
+	s := chartSeries{Name: name}
+
+	for _, p := range latencyPercentiles {
+		d, ok := bucketPercentile(l.Histogram, p)
+
+		if !ok {
+			break
+		}
+
+		s.Points = append(s.Points, chartPoint{X: nines(p), Y: float64(d) / float64(time.Millisecond)})
+	}
+
+	return s
+}
+
+// maxBatchSizeBars is the number of bars above which batch sizes are grouped into
+// ranges
+const maxBatchSizeBars = 20
+
+func batchSizeChart(b *BufferReport) barChart {
+	chart := barChart{
+		Title:  "Batch size distribution",
+		XLabel: "rows per batch",
+		YLabel: "batches",
+	}
+
+	if b == nil || len(b.BatchSizes) == 0 {
+		return chart
+	}
+
+	sizes := make([]int, 0, len(b.BatchSizes))
+
+	for size := range b.BatchSizes {
+		sizes = append(sizes, size)
+	}
+
+	sort.Ints(sizes)
+
+	if len(sizes) <= maxBatchSizeBars {
+		for _, size := range sizes {
+			chart.Labels = append(chart.Labels, strconv.Itoa(size))
+			chart.Values = append(chart.Values, float64(b.BatchSizes[size]))
+		}
+
+		return chart
+	}
+
+	lo, hi := sizes[0], sizes[len(sizes)-1]
+	width := (hi - lo + maxBatchSizeBars) / maxBatchSizeBars
+
+	chart.Values = make([]float64, maxBatchSizeBars)
+
+	for i := range chart.Values {
+		start := lo + i*width
+		chart.Labels = append(chart.Labels, fmt.Sprintf("%d-%d", start, start+width-1))
+	}
+
+	for _, size := range sizes {
+		chart.Values[(size-lo)/width] += float64(b.BatchSizes[size])
+	}
+
+	return chart
+}
+
+// sweepCharts plots throughput against the configured batch size, flush interval and
+// pipeline depth, and p99 latency against the pipeline depth, when the reports vary them
+func sweepCharts(reports []namedReport) []lineChart {
+	bySize := map[string][]chartPoint{}
+	byInterval := map[string][]chartPoint{}
+	sizes := map[int]bool{}
+	intervals := map[string]bool{}
+	depthThroughput := map[string][]chartPoint{}
+	depthLatency := map[string][]chartPoint{}
+	depths := map[int]bool{}
+
+	for _, r := range reports {
+		if p99, err := time.ParseDuration(r.Report.Latency.P99); r.Report.Pipeline > 0 && err == nil {
+			depths[r.Report.Pipeline] = true
+
+			sizeName := "batch size " + strconv.Itoa(r.Report.BatchSize)
+			depthThroughput[sizeName] = append(depthThroughput[sizeName], chartPoint{X: float64(r.Report.Pipeline), Y: r.Report.Throughput})
+			depthLatency[sizeName] = append(depthLatency[sizeName], chartPoint{X: float64(r.Report.Pipeline), Y: float64(p99) / float64(time.Millisecond)})
+		}
+
+		interval, err := time.ParseDuration(r.Report.Interval)
+
+		if r.Report.BatchSize == 0 || err != nil {
+			continue
+		}
+
+		sizes[r.Report.BatchSize] = true
+		intervals[r.Report.Interval] = true
+
+		bySize[r.Report.Interval] = append(bySize[r.Report.Interval], chartPoint{X: float64(r.Report.BatchSize), Y: r.Report.Throughput})
+
+		sizeName := "batch size " + strconv.Itoa(r.Report.BatchSize)
+		byInterval[sizeName] = append(byInterval[sizeName], chartPoint{X: float64(interval) / float64(time.Millisecond), Y: r.Report.Throughput})
+	}
+
+	charts := []lineChart{}
+
+	toSeries := func(prefix string, m map[string][]chartPoint) []chartSeries {
+		series := []chartSeries{}
+
+		for _, name := range sortedKeys(m) {
+			points := m[name]
+
+			sort.Slice(points, func(i, j int) bool {
+				return points[i].X < points[j].X
+			})
+
+			series = append(series, chartSeries{Name: prefix + name, Points: points})
+		}
+
+		return series
+	}
+
+	if len(sizes) > 1 {
+		charts = append(charts, lineChart{
+			Title:  "Throughput vs. batch size",
+			XLabel: "batch size (rows)",
+			YLabel: "rows/second",
+			Series: toSeries("flush interval ", bySize),
+		})
+	}
+
+	if len(intervals) > 1 {
+		charts = append(charts, lineChart{
+			Title:  "Throughput vs. flush interval",
+			XLabel: "flush interval (ms)",
+			YLabel: "rows/second",
+			Series: toSeries("", byInterval),
+		})
+	}
+
+	if len(depths) > 1 {
+		charts = append(charts, lineChart{
+			Title:  "Throughput vs. pipeline depth",
+			XLabel: "batches in flight per connection",
+			YLabel: "rows/second",
+			Series: toSeries("", depthThroughput),
+		}, lineChart{
+			Title:  "p99 latency vs. pipeline depth",
+			XLabel: "batches in flight per connection",
+			YLabel: "p99 latency (ms)",
+			Series: toSeries("", depthLatency),
+		})
+	}
+
+	return charts
+}
+
+// htmlData is passed to htmlTemplate
+type htmlData struct {
+	Title     string
+	Generated string
+	Reports   []namedReport
+	Charts    []template.HTML
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 820px; color: #111827; }
+table { border-collapse: collapse; margin-bottom: 2em; font-size: 14px; }
+th, td { border-bottom: 1px solid #e5e7eb; padding: 4px 10px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.chart { width: 100%; margin-bottom: 2em; }
+.chart .title { font-size: 15px; font-weight: 600; text-anchor: middle; }
+.chart .label { font-size: 12px; text-anchor: middle; fill: #374151; }
+.chart .xtick { font-size: 11px; text-anchor: middle; fill: #6b7280; }
+.chart .ytick { font-size: 11px; text-anchor: end; fill: #6b7280; }
+.chart .legend { font-size: 12px; fill: #374151; }
+.chart .grid { stroke: #e5e7eb; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Generated {{.Generated}}</p>
+<table>
+<tr><th>run</th><th>rows</th><th>failed</th><th>total time</th><th>rows/second</th><th>p50</th><th>p99</th><th>max</th><th>avg batch size</th></tr>
+{{range .Reports}}<tr><td>{{.Name}}</td><td>{{.Report.TaskCount}}</td><td>{{.Report.FailedCount}}</td><td>{{.Report.TotalTime}}</td><td>{{printf "%.2f" .Report.Throughput}}</td><td>{{.Report.Latency.P50}}</td><td>{{.Report.Latency.P99}}</td><td>{{.Report.Latency.Max}}</td><td>{{.Report.AvgBatchSize}}</td></tr>
+{{end}}</table>
+{{range .Charts}}{{.}}
+{{end}}</body>
+</html>
+`))
+
+// writeHTMLReport writes a self-contained HTML report with inline SVG charts. A single
+// report gets charts for its own latency stages and batch sizes, while several
+// reports are overlaid on each other and plotted against their settings.
+func writeHTMLReport(path string, reports []namedReport) error {
+	data := htmlData{
+		Title:     "Insert benchmark report",
+		Generated: time.Now().Format(time.RFC1123),
+		Reports:   reports,
+	}
+
+	throughput := lineChart{
+		Title:  "Throughput over time",
+		XLabel: "seconds",
+		YLabel: "rows/second",
+	}
+
+	latency := lineChart{
+		Title:   "End-to-end latency percentiles",
+		XLabel:  "percentile",
+		YLabel:  "latency (ms)",
+		FormatX: formatNines,
+	}
+
+	for _, r := range reports {
+		throughput.Series = append(throughput.Series, throughputSeries(r))
+		latency.Series = append(latency.Series, latencySeries(r.Name, r.Report.Latency))
+	}
+
+	if len(reports) == 1 {
+		r := reports[0].Report
+
+		latency.Title = "Latency percentiles"
+		latency.Series = []chartSeries{
+			latencySeries("end-to-end", r.Latency),
+			latencySeries("queue wait", r.QueueWait),
+			latencySeries("DB write", r.DBLatency),
+		}
+	}
+
+	charts := []template.HTML{throughput.svg(), latency.svg()}
+
+	if len(reports) == 1 {
+		charts = append(charts, batchSizeChart(reports[0].Report.Buffer).svg())
+	}
+
+	for _, c := range sweepCharts(reports) {
+		charts = append(charts, c.svg())
+	}
+
+	// charts without any data render as empty strings
+	for _, c := range charts {
+		if c != "" {
+			data.Charts = append(data.Charts, c)
+		}
+	}
+
+	f, err := os.Create(path)
+
+	if err != nil {
+		return err
+	}
+
+	if err := htmlTemplate.Execute(f, data); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
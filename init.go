@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/abelanger5/postgres-fast-inserts/internal/dbsqlc"
+	"github.com/abelanger5/postgres-fast-inserts/pkg/buffer"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
 )
 
 var pool *pgxpool.Pool
@@ -20,29 +24,38 @@ var batchSize int
 var channelBufferSize int
 var jsonOutput bool
 var withAssociatedData bool
+var adaptiveBatching bool
+var targetLatency time.Duration
+var drainTimeout time.Duration
+var bisectFailures bool
+var retryAttempts int
+var retryBackoff time.Duration
+var retryMaxBackoff time.Duration
+var retryBudget time.Duration
+var maxBatchKB int
+var maxBufferedKB int
+var backpressure string
+var partitions int
+var partitionKeys int
+var resultGoroutines bool
+var reportInterval time.Duration
+var metricsAddr string
+var backpressurePolicy buffer.BackpressurePolicy
+var rate string
+var arrival string
+var arrivalRate float64
+var arrivalProcess ArrivalProcess
+var execMode string
 
 func init() {
 	rootCmd.PersistentFlags().IntVarP(&maxConns, "max-conns", "m", 20, "maximum number of connections to the database")
 
-	dbUrl := os.Getenv("DATABASE_URL")
-
-	if dbUrl == "" {
-		log.Fatal("DATABASE_URL must be set")
-	}
-
-	config, err := pgxpool.ParseConfig(dbUrl)
-
-	if err != nil {
-		log.Fatal("could not parse DATABASE_URL: %w", err)
-	}
-
-	config.MaxConns = int32(maxConns)
-
-	pool, err = pgxpool.NewWithConfig(context.Background(), config)
-
-	if err != nil {
-		log.Fatal("could not create connection pool: %w", err)
-	}
+	rootCmd.PersistentFlags().StringVar(
+		&execMode,
+		"exec-mode",
+		"cache_statement",
+		"pgx query execution mode, not used by continuous pipeline: "+strings.Join(execModeNames, ", "),
+	)
 
 	queries = dbsqlc.New()
 
@@ -59,6 +72,10 @@ func init() {
 	continuousCmd.AddCommand(continuousSingletonCmd)
 	continuousCmd.AddCommand(continuousBatchCmd)
 	continuousCmd.AddCommand(continuousCopyFromCmd)
+	continuousCmd.AddCommand(continuousUnnestCmd)
+	continuousCmd.AddCommand(continuousValuesCmd)
+	continuousCmd.AddCommand(continuousCopyStreamCmd)
+	continuousCmd.AddCommand(continuousPipelineCmd)
 	continuousCmd.AddCommand(continuousPingCmd)
 
 	continuousCmd.PersistentFlags().IntVarP(
@@ -69,10 +86,6 @@ func init() {
 		"number of continuous writers",
 	)
 
-	if continuousWritersCount > maxConns {
-		log.Fatalf("number of writers (%d) cannot be greater than max connections (%d). increase max connections via the --max-conns flag", continuousWritersCount, maxConns)
-	}
-
 	continuousCmd.PersistentFlags().DurationVarP(
 		&benchmarkDuration,
 		"duration",
@@ -111,4 +124,201 @@ func init() {
 		10*time.Millisecond,
 		"interval to flush the buffer",
 	)
+
+	continuousCmd.PersistentFlags().BoolVar(
+		&adaptiveBatching,
+		"adaptive",
+		false,
+		"adapt the batch size and flush interval at runtime based on write latency",
+	)
+
+	continuousCmd.PersistentFlags().DurationVar(
+		&targetLatency,
+		"target-latency",
+		10*time.Millisecond,
+		"target write latency for adaptive batching",
+	)
+
+	continuousCmd.PersistentFlags().DurationVar(
+		&drainTimeout,
+		"drain-timeout",
+		30*time.Second,
+		"maximum time to flush the remaining buffered tasks at the end of the run",
+	)
+
+	continuousCmd.PersistentFlags().BoolVar(
+		&bisectFailures,
+		"bisect-failures",
+		false,
+		"split failed batches and retry the halves so that only the failing rows return an error",
+	)
+
+	continuousCmd.PersistentFlags().IntVar(
+		&retryAttempts,
+		"retry-attempts",
+		1,
+		"maximum number of attempts for writes which fail with a transient error (1 disables retries)",
+	)
+
+	continuousCmd.PersistentFlags().DurationVar(
+		&retryBackoff,
+		"retry-backoff",
+		10*time.Millisecond,
+		"initial backoff between retries, doubled after each retry",
+	)
+
+	continuousCmd.PersistentFlags().DurationVar(
+		&retryMaxBackoff,
+		"retry-max-backoff",
+		time.Second,
+		"maximum backoff between retries",
+	)
+
+	continuousCmd.PersistentFlags().DurationVar(
+		&retryBudget,
+		"retry-budget",
+		5*time.Second,
+		"maximum time spent retrying a single write",
+	)
+
+	continuousCmd.PersistentFlags().IntVar(
+		&maxBatchKB,
+		"max-batch-kb",
+		0,
+		"maximum total payload size of a batch in kilobytes (0 for no limit)",
+	)
+
+	continuousCmd.PersistentFlags().IntVar(
+		&maxBufferedKB,
+		"max-buffered-kb",
+		0,
+		"maximum total payload size of buffered tasks in kilobytes, writers are subject to --backpressure when it is exceeded (0 for no limit)",
+	)
+
+	continuousCmd.PersistentFlags().StringVar(
+		&backpressure,
+		"backpressure",
+		"timeout",
+		"what to do with new tasks when the buffer is full: timeout, block, reject or drop-oldest",
+	)
+
+	continuousCmd.PersistentFlags().IntVar(
+		&partitions,
+		"partitions",
+		0,
+		"split the buffer into n lanes which are flushed serially to preserve per-key write order (0 to disable)",
+	)
+
+	continuousCmd.PersistentFlags().IntVar(
+		&partitionKeys,
+		"keys",
+		100,
+		"number of distinct partition keys (tenants) to spread tasks over, tasks with the same key are written in order with --partitions (0 for a unique key per task)",
+	)
+
+	continuousCmd.PersistentFlags().BoolVar(
+		&resultGoroutines,
+		"result-goroutines",
+		false,
+		"wait for each task's result in its own goroutine instead of using result callbacks",
+	)
+
+	continuousCmd.PersistentFlags().DurationVar(
+		&reportInterval,
+		"report-interval",
+		0,
+		"print throughput, latency, batches and errors to stderr at this interval (e.g. 1s, 0 to disable)",
+	)
+
+	continuousCmd.PersistentFlags().StringVar(
+		&metricsAddr,
+		"metrics-addr",
+		"",
+		"serve Prometheus metrics on this address during the run (e.g. :9100)",
+	)
+
+	continuousCmd.PersistentFlags().StringVar(
+		&rate,
+		"rate",
+		"",
+		"generate tasks open-loop at this rate (e.g. 50000/s) and measure latency from each task's scheduled time",
+	)
+
+	continuousCmd.PersistentFlags().StringVar(
+		&arrival,
+		"arrival",
+		"constant",
+		"open-loop arrival process: constant or poisson",
+	)
+
+	basicCmd.PersistentPreRun = connect
+	concurrentCmd.PersistentPreRun = connect
+
+	continuousCopyStreamCmd.Flags().AddFlagSet(copyFormatFlags)
+
+	continuousPipelineCmd.Flags().IntVar(
+		&pipelineDepth,
+		"pipeline-depth",
+		4,
+		"number of batches each writer keeps in flight before reading their results",
+	)
+
+	continuousCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if continuousWritersCount > maxConns {
+			return fmt.Errorf("number of writers (%d) cannot be greater than max connections (%d). increase max connections via the --max-conns flag", continuousWritersCount, maxConns)
+		}
+
+		connect(cmd, args)
+
+		var err error
+
+		backpressurePolicy, err = buffer.ParseBackpressurePolicy(backpressure)
+
+		if err != nil {
+			return err
+		}
+
+		arrivalProcess, err = ParseArrivalProcess(arrival)
+
+		if err != nil {
+			return err
+		}
+
+		if rate != "" {
+			arrivalRate, err = parseRate(rate)
+		}
+
+		return err
+	}
+}
+
+// connect creates the connection pool. It runs before the database commands rather
+// than in init, so that the pool is configured from the parsed flags.
+func connect(cmd *cobra.Command, args []string) {
+	dbUrl := os.Getenv("DATABASE_URL")
+
+	if dbUrl == "" {
+		log.Fatal("DATABASE_URL must be set")
+	}
+
+	config, err := pgxpool.ParseConfig(dbUrl)
+
+	if err != nil {
+		log.Fatalf("could not parse DATABASE_URL: %v", err)
+	}
+
+	mode, err := parseExecMode(execMode)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config.MaxConns = int32(maxConns)
+	config.ConnConfig.DefaultQueryExecMode = mode
+
+	pool, err = pgxpool.NewWithConfig(context.Background(), config)
+
+	if err != nil {
+		log.Fatalf("could not create connection pool: %v", err)
+	}
 }
@@ -7,6 +7,7 @@ package dbsqlc
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/jackc/pgx/v5/pgtype"
 )
@@ -18,8 +19,8 @@ RETURNING id, created_at, args, idempotency_key
 `
 
 type InsertTaskSingletonParams struct {
-	Args           []byte      `json:"args"`
-	IdempotencyKey pgtype.Text `json:"idempotency_key"`
+	Args           json.RawMessage `json:"args"`
+	IdempotencyKey pgtype.Text     `json:"idempotency_key"`
 }
 
 func (q *Queries) InsertTaskSingleton(ctx context.Context, db DBTX, arg InsertTaskSingletonParams) (*Task, error) {
@@ -35,8 +36,8 @@ func (q *Queries) InsertTaskSingleton(ctx context.Context, db DBTX, arg InsertTa
 }
 
 type InsertTasksCopyFromParams struct {
-	Args           []byte      `json:"args"`
-	IdempotencyKey pgtype.Text `json:"idempotency_key"`
+	Args           json.RawMessage `json:"args"`
+	IdempotencyKey pgtype.Text     `json:"idempotency_key"`
 }
 
 const insertTasksWithUnnest = `-- name: InsertTasksWithUnnest :many
@@ -54,8 +55,8 @@ RETURNING id, created_at, args, idempotency_key
 `
 
 type InsertTasksWithUnnestParams struct {
-	Args [][]byte `json:"args"`
-	Keys []string `json:"keys"`
+	Args []json.RawMessage `json:"args"`
+	Keys []string          `json:"keys"`
 }
 
 func (q *Queries) InsertTasksWithUnnest(ctx context.Context, db DBTX, arg InsertTasksWithUnnestParams) ([]*Task, error) {
@@ -7,6 +7,7 @@ package dbsqlc
 
 import (
 	"context"
+	"encoding/json"
 )
 
 const insertTaskAssociatedData = `-- name: InsertTaskAssociatedData :exec
@@ -15,8 +16,8 @@ VALUES ($1, extract_top_level_fields($2))
 `
 
 type InsertTaskAssociatedDataParams struct {
-	TaskID   int64  `json:"task_id"`
-	ArgsJson []byte `json:"args_json"`
+	TaskID   int64           `json:"task_id"`
+	ArgsJson json.RawMessage `json:"args_json"`
 }
 
 func (q *Queries) InsertTaskAssociatedData(ctx context.Context, db DBTX, arg InsertTaskAssociatedDataParams) error {
@@ -7,6 +7,7 @@ package dbsqlc
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 
 	"github.com/jackc/pgx/v5"
@@ -30,8 +31,8 @@ type InsertTaskAssociatedDatasBatchBatchResults struct {
 }
 
 type InsertTaskAssociatedDatasBatchParams struct {
-	TaskID   int64  `json:"task_id"`
-	ArgsJson []byte `json:"args_json"`
+	TaskID   int64           `json:"task_id"`
+	ArgsJson json.RawMessage `json:"args_json"`
 }
 
 func (q *Queries) InsertTaskAssociatedDatasBatch(ctx context.Context, db DBTX, arg []InsertTaskAssociatedDatasBatchParams) *InsertTaskAssociatedDatasBatchBatchResults {
@@ -83,8 +84,8 @@ type InsertTasksBatchBatchResults struct {
 }
 
 type InsertTasksBatchParams struct {
-	Args           []byte      `json:"args"`
-	IdempotencyKey pgtype.Text `json:"idempotency_key"`
+	Args           json.RawMessage `json:"args"`
+	IdempotencyKey pgtype.Text     `json:"idempotency_key"`
 }
 
 func (q *Queries) InsertTasksBatch(ctx context.Context, db DBTX, arg []InsertTasksBatchParams) *InsertTasksBatchBatchResults {
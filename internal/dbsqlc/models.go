@@ -5,13 +5,15 @@
 package dbsqlc
 
 import (
+	"encoding/json"
+
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type Task struct {
 	ID             int64              `json:"id"`
 	CreatedAt      pgtype.Timestamptz `json:"created_at"`
-	Args           []byte             `json:"args"`
+	Args           json.RawMessage    `json:"args"`
 	IdempotencyKey pgtype.Text        `json:"idempotency_key"`
 }
 
@@ -0,0 +1,211 @@
+// Package histogram records durations in log-linear buckets, in the style of HDR
+// histograms, so that percentiles can be reported with bounded relative error and
+// constant memory.
+package histogram
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// subBucketBits sets the precision: every power of two is split into 2^(subBucketBits-1)
+// linear buckets, which bounds the relative error of a recorded value to 1/64 (about 1.6%).
+const (
+	subBucketBits  = 7
+	subBucketCount = 1 << subBucketBits
+	subBucketHalf  = subBucketCount / 2
+)
+
+// Histogram records durations. It is not safe for concurrent use.
+type Histogram struct {
+	counts []int64
+	count  int64
+	sum    int64
+	min    int64
+	max    int64
+}
+
+// Bucket is a range of values and the number of values recorded in it
+type Bucket struct {
+	LowerBound time.Duration `json:"lowerBoundNs"`
+	UpperBound time.Duration `json:"upperBoundNs"`
+	Count      int64         `json:"count"`
+}
+
+// New creates an empty histogram
+func New() *Histogram {
+	return &Histogram{}
+}
+
+func bucketIndex(v int64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+
+	exp := bits.Len64(uint64(v)) - subBucketBits
+
+	return exp*subBucketHalf + int(v>>exp)
+}
+
+func bucketBounds(i int) (int64, int64) {
+	if i < subBucketCount {
+		return int64(i), int64(i)
+	}
+
+	exp := i/subBucketHalf - 1
+	sub := int64(i - exp*subBucketHalf)
+
+	return sub << exp, (sub+1)<<exp - 1
+}
+
+// Record adds a duration to the histogram. Negative durations are recorded as zero.
+func (h *Histogram) Record(d time.Duration) {
+	v := max(int64(d), 0)
+
+	i := bucketIndex(v)
+
+	if i >= len(h.counts) {
+		counts := make([]int64, i+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+
+	h.counts[i]++
+
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+
+	if v > h.max {
+		h.max = v
+	}
+
+	h.count++
+	h.sum += v
+}
+
+// Merge adds every value recorded in other to h
+func (h *Histogram) Merge(other *Histogram) {
+	if other.count == 0 {
+		return
+	}
+
+	if len(other.counts) > len(h.counts) {
+		counts := make([]int64, len(other.counts))
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+
+	h.max = max(h.max, other.max)
+	h.count += other.count
+	h.sum += other.sum
+}
+
+// Reset removes all recorded values
+func (h *Histogram) Reset() {
+	*h = Histogram{}
+}
+
+// Count returns the number of recorded values
+func (h *Histogram) Count() int64 {
+	return h.count
+}
+
+// Min returns the smallest recorded value
+func (h *Histogram) Min() time.Duration {
+	return time.Duration(h.min)
+}
+
+// Max returns the largest recorded value
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(h.max)
+}
+
+// Mean returns the exact mean of the recorded values
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	return time.Duration(h.sum / h.count)
+}
+
+// Percentile returns the value below which p percent of the recorded values fall, to
+// within the precision of the buckets. p is in the range [0, 100], and p0 and p100 are
+// the exact min and max.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	if p <= 0 {
+		return time.Duration(h.min)
+	}
+
+	rank := int64(math.Ceil(p / 100 * float64(h.count)))
+	rank = min(max(rank, 1), h.count)
+
+	var seen int64
+
+	for i, c := range h.counts {
+		seen += c
+
+		if seen >= rank {
+			_, upper := bucketBounds(i)
+			return time.Duration(min(max(upper, h.min), h.max))
+		}
+	}
+
+	return time.Duration(h.max)
+}
+
+// CountAtOrBelow returns the number of recorded values in buckets whose upper bound is
+// at most d
+func (h *Histogram) CountAtOrBelow(d time.Duration) int64 {
+	var count int64
+
+	for i, c := range h.counts {
+		if _, upper := bucketBounds(i); upper > int64(d) {
+			break
+		}
+
+		count += c
+	}
+
+	return count
+}
+
+// Sum returns the sum of the recorded values
+func (h *Histogram) Sum() time.Duration {
+	return time.Duration(h.sum)
+}
+
+// Buckets returns the non-empty buckets in ascending order
+func (h *Histogram) Buckets() []Bucket {
+	buckets := make([]Bucket, 0)
+
+	for i, c := range h.counts {
+		if c == 0 {
+			continue
+		}
+
+		lower, upper := bucketBounds(i)
+
+		buckets = append(buckets, Bucket{
+			LowerBound: time.Duration(lower),
+			UpperBound: time.Duration(upper),
+			Count:      c,
+		})
+	}
+
+	return buckets
+}
@@ -0,0 +1,192 @@
+package histogram
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+const maxRelativeError = 1.0 / 64
+
+func relativeError(got, want time.Duration) float64 {
+	diff := float64(got - want)
+
+	if diff < 0 {
+		diff = -diff
+	}
+
+	return diff / float64(want)
+}
+
+func TestBucketRelativeError(t *testing.T) {
+	values := []int64{0, 1, 63, 127, 128, 129, 255, 256, 1000, 4095, 4096, 65537}
+
+	// values around every power of two up to an hour, and at every order of magnitude
+	for v := int64(1); v < int64(time.Hour); v *= 2 {
+		values = append(values, v-1, v, v+1)
+	}
+
+	for v := int64(1); v <= int64(time.Hour); v *= 10 {
+		values = append(values, v, v*3+7)
+	}
+
+	for _, v := range values {
+		lower, upper := bucketBounds(bucketIndex(v))
+
+		if v < lower || v > upper {
+			t.Fatalf("value %d is outside its bucket [%d, %d]", v, lower, upper)
+		}
+
+		// Percentile reports the upper bound of a bucket
+		if v > 0 && float64(upper-v)/float64(v) > maxRelativeError {
+			t.Fatalf("bucket [%d, %d] for value %d is wider than 1/64 of the value", lower, upper, v)
+		}
+	}
+}
+
+func TestBucketsAreContiguous(t *testing.T) {
+	_, prevUpper := bucketBounds(0)
+
+	for i := 1; i <= bucketIndex(int64(time.Hour)); i++ {
+		lower, upper := bucketBounds(i)
+
+		if lower != prevUpper+1 || upper < lower {
+			t.Fatalf("bucket %d is [%d, %d], previous bucket ended at %d", i, lower, upper, prevUpper)
+		}
+
+		prevUpper = upper
+	}
+}
+
+func TestPercentileRelativeError(t *testing.T) {
+	for v := time.Duration(1); v < time.Hour; v = v*3 + 1 {
+		h := New()
+		h.Record(v)
+		// a larger value keeps Percentile from clamping the result to the max
+		h.Record(2 * time.Hour)
+
+		got := h.Percentile(50)
+
+		if got < v || relativeError(got, v) > maxRelativeError {
+			t.Fatalf("p50 of %s: got %s, want within 1/64 above", v, got)
+		}
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	uniform := New()
+
+	for i := 1; i <= 10000; i++ {
+		uniform.Record(time.Duration(i) * time.Microsecond)
+	}
+
+	skewed := New()
+
+	for i := 0; i < 99; i++ {
+		skewed.Record(time.Millisecond)
+	}
+
+	skewed.Record(time.Second)
+
+	constant := New()
+
+	for i := 0; i < 10; i++ {
+		constant.Record(42 * time.Millisecond)
+	}
+
+	tests := []struct {
+		name string
+		h    *Histogram
+		p    float64
+		want time.Duration
+	}{
+		{name: "uniform p0 is the min", h: uniform, p: 0, want: time.Microsecond},
+		{name: "uniform p50", h: uniform, p: 50, want: 5000 * time.Microsecond},
+		{name: "uniform p99", h: uniform, p: 99, want: 9900 * time.Microsecond},
+		{name: "uniform p100 is the max", h: uniform, p: 100, want: 10000 * time.Microsecond},
+		{name: "skewed p50", h: skewed, p: 50, want: time.Millisecond},
+		{name: "skewed p99 excludes the outlier", h: skewed, p: 99, want: time.Millisecond},
+		{name: "skewed p100 is the outlier", h: skewed, p: 100, want: time.Second},
+		{name: "constant p0", h: constant, p: 0, want: 42 * time.Millisecond},
+		{name: "constant p99", h: constant, p: 99, want: 42 * time.Millisecond},
+		{name: "empty", h: New(), p: 50, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.h.Percentile(tt.p)
+
+			if tt.want == 0 {
+				if got != 0 {
+					t.Fatalf("got %s, want 0", got)
+				}
+
+				return
+			}
+
+			if relativeError(got, tt.want) > maxRelativeError {
+				t.Fatalf("got %s, want %s within 1/64", got, tt.want)
+			}
+		})
+	}
+
+	if uniform.Percentile(0) != uniform.Min() || uniform.Percentile(100) != uniform.Max() {
+		t.Fatalf("p0 and p100 should be the exact min and max, got %s and %s", uniform.Percentile(0), uniform.Percentile(100))
+	}
+}
+
+func TestRecordNegative(t *testing.T) {
+	h := New()
+	h.Record(-time.Second)
+
+	if h.Count() != 1 || h.Min() != 0 || h.Max() != 0 {
+		t.Fatalf("got count %d, min %s, max %s, want a single zero", h.Count(), h.Min(), h.Max())
+	}
+}
+
+func TestMerge(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	all, left, right := New(), New(), New()
+
+	for i := 0; i < 5000; i++ {
+		v := time.Duration(rng.ExpFloat64() * float64(time.Millisecond))
+
+		all.Record(v)
+
+		if i%3 == 0 {
+			left.Record(v)
+		} else {
+			right.Record(v)
+		}
+	}
+
+	// right has more buckets than left, so merging it has to grow the counts
+	right.Record(time.Minute)
+	all.Record(time.Minute)
+
+	merged := New()
+	merged.Merge(left)
+	merged.Merge(right)
+	merged.Merge(New())
+
+	if merged.Count() != all.Count() || merged.Sum() != all.Sum() ||
+		merged.Min() != all.Min() || merged.Max() != all.Max() {
+		t.Fatalf("merged: count %d, sum %s, min %s, max %s; want count %d, sum %s, min %s, max %s",
+			merged.Count(), merged.Sum(), merged.Min(), merged.Max(),
+			all.Count(), all.Sum(), all.Min(), all.Max())
+	}
+
+	for _, p := range []float64{0, 50, 90, 99, 99.9, 100} {
+		if got, want := merged.Percentile(p), all.Percentile(p); got != want {
+			t.Fatalf("p%v: got %s, want %s", p, got, want)
+		}
+	}
+
+	onlyLeft := New()
+	onlyLeft.Merge(left)
+
+	if onlyLeft.Min() != left.Min() || onlyLeft.Percentile(50) != left.Percentile(50) {
+		t.Fatalf("merging into an empty histogram changed it")
+	}
+}
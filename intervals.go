@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/abelanger5/postgres-fast-inserts/internal/histogram"
+)
+
+// IntervalReport represents the metrics for a single reporting interval
+type IntervalReport struct {
+	Elapsed    string        `json:"elapsed"`
+	Seconds    float64       `json:"seconds"`
+	Tasks      int           `json:"tasks"`
+	Throughput float64       `json:"throughput"`
+	Batches    int           `json:"batches"`
+	Errors     int           `json:"errors"`
+	Latency    LatencyReport `json:"latency"`
+}
+
+// intervalMetrics holds the counters for the current reporting interval and the
+// per-second task counts used for the stability summary
+type intervalMetrics struct {
+	started time.Time
+	tasks   int
+	errors  int
+	latency *histogram.Histogram
+
+	// batchesAtStart is the reporter's batch count when the interval started
+	batchesAtStart int64
+
+	perSecond []int
+}
+
+func newIntervalMetrics(start time.Time) *intervalMetrics {
+	return &intervalMetrics{
+		started: start,
+		latency: histogram.New(),
+	}
+}
+
+func (m *intervalMetrics) recordTask(start time.Time, latency time.Duration) {
+	m.tasks++
+	m.latency.Record(latency)
+
+	second := int(time.Since(start) / time.Second)
+
+	for len(m.perSecond) <= second {
+		m.perSecond = append(m.perSecond, 0)
+	}
+
+	m.perSecond[second]++
+}
+
+// StartIntervals prints a report line for every interval until ctx is done. Lines are
+// written to stderr, as NDJSON when --json is set, so that stdout only contains the
+// final report.
+func (r *Reporter) StartIntervals(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				printInterval(r.flushInterval())
+			}
+		}
+	}()
+}
+
+// flushInterval returns the report for the current interval and starts a new one
+func (r *Reporter) flushInterval() IntervalReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := r.interval
+	now := time.Now()
+	length := now.Sub(m.started)
+	batches := r.numBatches.Load()
+
+	report := IntervalReport{
+		Elapsed:    now.Sub(r.start).Round(time.Millisecond).String(),
+		Seconds:    now.Sub(r.start).Seconds(),
+		Tasks:      m.tasks,
+		Throughput: float64(m.tasks) / length.Seconds(),
+		Batches:    int(batches - m.batchesAtStart),
+		Errors:     m.errors,
+		Latency:    newLatencyReport(m.latency, false),
+	}
+
+	r.intervals = append(r.intervals, report)
+
+	m.started = now
+	m.tasks = 0
+	m.batchesAtStart = batches
+	m.errors = 0
+	m.latency.Reset()
+
+	return report
+}
+
+func printInterval(report IntervalReport) {
+	if jsonOutput {
+		jsonBytes, err := json.Marshal(report)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating JSON: %v\n", err)
+			return
+		}
+
+		fmt.Fprintln(os.Stderr, string(jsonBytes))
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "[%s] %.2f rows/second, %d batches, %d errors, latency p50 %s, p99 %s, max %s\n",
+		report.Elapsed, report.Throughput, report.Batches, report.Errors,
+		report.Latency.P50, report.Latency.P99, report.Latency.Max)
+}
+
+// completedSeconds returns the number of tasks completed in each second of the run,
+// ignoring the final partial second. The caller must hold the reporter's lock.
+func (r *Reporter) completedSeconds() []int {
+	perSecond := r.interval.perSecond
+
+	if len(perSecond) == 0 {
+		return nil
+	}
+
+	return append([]int(nil), perSecond[:len(perSecond)-1]...)
+}
+
+// throughputStability returns the standard deviation and coefficient of variation of
+// the per-second throughput, ignoring the final partial second. The caller must hold
+// the reporter's lock.
+func (r *Reporter) throughputStability() (float64, float64) {
+	perSecond := r.completedSeconds()
+
+	if len(perSecond) < 2 {
+		return 0, 0
+	}
+
+	var sum float64
+
+	for _, n := range perSecond {
+		sum += float64(n)
+	}
+
+	mean := sum / float64(len(perSecond))
+
+	var variance float64
+
+	for _, n := range perSecond {
+		variance += (float64(n) - mean) * (float64(n) - mean)
+	}
+
+	stddev := math.Sqrt(variance / float64(len(perSecond)))
+
+	if mean == 0 {
+		return stddev, 0
+	}
+
+	return stddev, stddev / mean
+}
@@ -16,6 +16,7 @@ var rootCmd = &cobra.Command{
 }
 
 var maxPayloadSize int
+var serverStats bool
 
 func init() {
 	rootCmd.PersistentFlags().IntVar(
@@ -24,6 +25,13 @@ func init() {
 		1000,
 		"maximum size of the payload in kilobytes",
 	)
+
+	rootCmd.PersistentFlags().BoolVar(
+		&serverStats,
+		"server-stats",
+		false,
+		"report server-side WAL, commit, buffer and pg_stat_statements deltas for the run",
+	)
 }
 
 func main() {
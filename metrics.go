@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/abelanger5/postgres-fast-inserts/internal/histogram"
+)
+
+// latencyBuckets are the upper bounds of the exported latency histogram
+var latencyBuckets = []time.Duration{
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// metricsSnapshot is a copy of the reporter's counters taken for a scrape
+type metricsSnapshot struct {
+	tasks   int
+	batches int
+	errors  int
+	latency *histogram.Histogram
+}
+
+func (r *Reporter) metricsSnapshot() metricsSnapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	latency := histogram.New()
+	latency.Merge(r.latency)
+
+	return metricsSnapshot{
+		tasks:   r.taskCount,
+		batches: int(r.numBatches.Load()),
+		errors:  r.errorCount,
+		latency: latency,
+	}
+}
+
+// serveMetrics serves the reporter's metrics, the buffer queue depth and the pool
+// statistics in the Prometheus text format on --metrics-addr until the returned
+// function is called. queueDepth may be nil for strategies without a buffer.
+func serveMetrics(reporter *Reporter, queueDepth func() int) func() {
+	if metricsAddr == "" {
+		return func() {}
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, reporter.metricsSnapshot(), queueDepth)
+	})
+
+	server := &http.Server{
+		Addr:              metricsAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("could not serve metrics: %v", err)
+		}
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("could not shut down metrics server: %v", err)
+		}
+	}
+}
+
+func writeMetric(w io.Writer, name, metricType, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+	fmt.Fprintf(w, "%s %g\n", name, value)
+}
+
+func writeMetrics(w io.Writer, snapshot metricsSnapshot, queueDepth func() int) {
+	writeMetric(w, "inserts_rows_total", "counter", "Number of rows written.", float64(snapshot.tasks))
+	writeMetric(w, "inserts_batches_total", "counter", "Number of batches written.", float64(snapshot.batches))
+	writeMetric(w, "inserts_errors_total", "counter", "Number of rows which failed to be written.", float64(snapshot.errors))
+
+	fmt.Fprintf(w, "# HELP inserts_latency_seconds Latency of each row from submission to result.\n")
+	fmt.Fprintf(w, "# TYPE inserts_latency_seconds histogram\n")
+
+	for _, le := range latencyBuckets {
+		fmt.Fprintf(w, "inserts_latency_seconds_bucket{le=\"%g\"} %d\n", le.Seconds(), snapshot.latency.CountAtOrBelow(le))
+	}
+
+	fmt.Fprintf(w, "inserts_latency_seconds_bucket{le=\"+Inf\"} %d\n", snapshot.latency.Count())
+	fmt.Fprintf(w, "inserts_latency_seconds_sum %g\n", snapshot.latency.Sum().Seconds())
+	fmt.Fprintf(w, "inserts_latency_seconds_count %d\n", snapshot.latency.Count())
+
+	if queueDepth != nil {
+		writeMetric(w, "inserts_buffer_queue_depth", "gauge", "Number of rows waiting in the buffer.", float64(queueDepth()))
+	}
+
+	stat := pool.Stat()
+
+	writeMetric(w, "inserts_pool_acquired_conns", "gauge", "Number of connections currently acquired from the pool.", float64(stat.AcquiredConns()))
+	writeMetric(w, "inserts_pool_idle_conns", "gauge", "Number of idle connections in the pool.", float64(stat.IdleConns()))
+	writeMetric(w, "inserts_pool_constructing_conns", "gauge", "Number of connections being established.", float64(stat.ConstructingConns()))
+	writeMetric(w, "inserts_pool_total_conns", "gauge", "Total number of connections in the pool.", float64(stat.TotalConns()))
+	writeMetric(w, "inserts_pool_max_conns", "gauge", "Maximum size of the pool.", float64(stat.MaxConns()))
+	writeMetric(w, "inserts_pool_acquire_total", "counter", "Number of successful acquires from the pool.", float64(stat.AcquireCount()))
+	writeMetric(w, "inserts_pool_acquire_duration_seconds_total", "counter", "Total time spent acquiring connections.", stat.AcquireDuration().Seconds())
+	writeMetric(w, "inserts_pool_empty_acquire_total", "counter", "Number of acquires which waited for a connection.", float64(stat.EmptyAcquireCount()))
+	writeMetric(w, "inserts_pool_canceled_acquire_total", "counter", "Number of acquires canceled by a context.", float64(stat.CanceledAcquireCount()))
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/abelanger5/postgres-fast-inserts/pkg/buffer"
+)
+
+// bufferMetrics aggregates the events emitted by a buffer. Most events happen once per
+// task on the buffer's hot path, so the counters are atomics rather than guarded by the
+// reporter's lock.
+type bufferMetrics struct {
+	enqueued      atomic.Int64
+	queueDepthSum atomic.Int64
+	maxQueueDepth atomic.Int64
+
+	flushes     triggerCounts
+	saturated   triggerCounts
+	flushErrors atomic.Int64
+	flushTime   atomic.Int64
+	maxInFlight atomic.Int64
+
+	batchSizesMu sync.Mutex
+	batchSizes   map[int]int64
+
+	results      atomic.Int64
+	queueWait    atomic.Int64
+	maxQueueWait atomic.Int64
+}
+
+// triggerCounts counts events by flush trigger. Its keys are fixed when it's created,
+// so it can be read concurrently.
+type triggerCounts map[buffer.FlushTrigger]*atomic.Int64
+
+func newTriggerCounts() triggerCounts {
+	counts := make(triggerCounts)
+
+	for _, trigger := range []buffer.FlushTrigger{
+		buffer.FlushTriggerTicker,
+		buffer.FlushTriggerNotifier,
+		buffer.FlushTriggerShutdown,
+		buffer.FlushTriggerClose,
+	} {
+		counts[trigger] = &atomic.Int64{}
+	}
+
+	return counts
+}
+
+func (c triggerCounts) add(trigger buffer.FlushTrigger) {
+	if n, ok := c[trigger]; ok {
+		n.Add(1)
+	}
+}
+
+// byName returns the non-zero counts keyed by trigger name
+func (c triggerCounts) byName() map[string]int64 {
+	counts := make(map[string]int64)
+
+	for trigger, n := range c {
+		if v := n.Load(); v > 0 {
+			counts[trigger.String()] = v
+		}
+	}
+
+	return counts
+}
+
+func (c triggerCounts) total() int64 {
+	var total int64
+
+	for _, n := range c {
+		total += n.Load()
+	}
+
+	return total
+}
+
+// storeMax raises v to n if n is larger
+func storeMax(v *atomic.Int64, n int64) {
+	for {
+		current := v.Load()
+
+		if n <= current || v.CompareAndSwap(current, n) {
+			return
+		}
+	}
+}
+
+// BufferReport represents the buffer metrics for JSON output
+type BufferReport struct {
+	ResultDelivery     string           `json:"resultDelivery"`
+	Enqueued           int64            `json:"enqueued"`
+	AvgQueueDepth      float64          `json:"avgQueueDepth"`
+	MaxQueueDepth      int              `json:"maxQueueDepth"`
+	FlushesByTrigger   map[string]int64 `json:"flushesByTrigger"`
+	SaturatedByTrigger map[string]int64 `json:"saturatedByTrigger"`
+	FlushErrors        int64            `json:"flushErrors"`
+	AvgFlushDuration   string           `json:"avgFlushDuration"`
+	MaxInFlight        int              `json:"maxInFlight"`
+	AvgQueueWait       string           `json:"avgQueueWait"`
+	MaxQueueWait       string           `json:"maxQueueWait"`
+	BatchSizes         map[int]int64    `json:"batchSizes"`
+}
+
+// reporterObserver records buffer events on a Reporter
+type reporterObserver struct {
+	r *Reporter
+}
+
+// BufferObserver returns a buffer.Observer which records buffer metrics in the report
+func (r *Reporter) BufferObserver() buffer.Observer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.buffer == nil {
+		r.buffer = &bufferMetrics{
+			flushes:    newTriggerCounts(),
+			saturated:  newTriggerCounts(),
+			batchSizes: make(map[int]int64),
+		}
+	}
+
+	return &reporterObserver{r: r}
+}
+
+func (o *reporterObserver) OnEnqueue(queueDepth int) {
+	m := o.r.buffer
+	m.enqueued.Add(1)
+	m.queueDepthSum.Add(int64(queueDepth))
+	storeMax(&m.maxQueueDepth, int64(queueDepth))
+}
+
+func (o *reporterObserver) OnSaturated(trigger buffer.FlushTrigger) {
+	o.r.buffer.saturated.add(trigger)
+}
+
+func (o *reporterObserver) OnFlushStart(e buffer.FlushStartEvent) {
+	o.r.RecordBatch()
+
+	m := o.r.buffer
+	m.flushes.add(e.Trigger)
+	storeMax(&m.maxInFlight, int64(e.InFlight))
+}
+
+func (o *reporterObserver) OnFlushEnd(e buffer.FlushEndEvent) {
+	m := o.r.buffer
+	m.flushTime.Add(int64(e.Duration))
+
+	if e.Err != nil {
+		m.flushErrors.Add(1)
+	}
+
+	m.batchSizesMu.Lock()
+	defer m.batchSizesMu.Unlock()
+
+	m.batchSizes[e.BatchSize]++
+}
+
+func (o *reporterObserver) OnResult(e buffer.ResultEvent) {
+	m := o.r.buffer
+	m.results.Add(1)
+	m.queueWait.Add(int64(e.QueueWait))
+	storeMax(&m.maxQueueWait, int64(e.QueueWait))
+
+	if e.Err == nil {
+		o.r.recordStages(e.QueueWait, e.Write)
+	}
+}
+
+// report summarizes the metrics
+func (m *bufferMetrics) report() BufferReport {
+	m.batchSizesMu.Lock()
+	batchSizes := make(map[int]int64, len(m.batchSizes))
+
+	for size, n := range m.batchSizes {
+		batchSizes[size] = n
+	}
+
+	m.batchSizesMu.Unlock()
+
+	enqueued := m.enqueued.Load()
+	results := m.results.Load()
+	numFlushes := m.flushes.total()
+
+	report := BufferReport{
+		ResultDelivery:     "callback",
+		Enqueued:           enqueued,
+		MaxQueueDepth:      int(m.maxQueueDepth.Load()),
+		FlushesByTrigger:   m.flushes.byName(),
+		SaturatedByTrigger: m.saturated.byName(),
+		FlushErrors:        m.flushErrors.Load(),
+		MaxInFlight:        int(m.maxInFlight.Load()),
+		MaxQueueWait:       time.Duration(m.maxQueueWait.Load()).String(),
+		BatchSizes:         batchSizes,
+	}
+
+	if resultGoroutines {
+		report.ResultDelivery = "goroutine"
+	}
+
+	var avgFlushDuration, avgQueueWait time.Duration
+
+	if enqueued > 0 {
+		report.AvgQueueDepth = float64(m.queueDepthSum.Load()) / float64(enqueued)
+	}
+
+	if numFlushes > 0 {
+		avgFlushDuration = time.Duration(m.flushTime.Load() / numFlushes)
+	}
+
+	if results > 0 {
+		avgQueueWait = time.Duration(m.queueWait.Load() / results)
+	}
+
+	report.AvgFlushDuration = avgFlushDuration.String()
+	report.AvgQueueWait = avgQueueWait.String()
+
+	return report
+}
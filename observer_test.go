@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/abelanger5/postgres-fast-inserts/pkg/buffer"
+)
+
+func TestBufferObserver(t *testing.T) {
+	const numTasks = 1000
+
+	reporter := NewReporter()
+
+	b := buffer.New(context.Background(), buffer.Options[int]{
+		MaxBatchSize:         16,
+		MaxConcurrentFlushes: 4,
+		Linger:               time.Millisecond,
+		ChannelCapacity:      numTasks,
+		Observer:             reporter.BufferObserver(),
+	}, func(tasks []int) ([]*int, error) {
+		out := make([]*int, len(tasks))
+
+		for i := range tasks {
+			out[i] = &tasks[i]
+		}
+
+		return out, nil
+	})
+
+	// write from several goroutines so that the race detector sees the observer's
+	// callbacks running concurrently
+	var wg sync.WaitGroup
+
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := 0; i < numTasks/8; i++ {
+				if _, err := b.Write(i); err != nil {
+					t.Errorf("write failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	report := reporter.buffer.report()
+	stats := b.Stats()
+
+	if report.Enqueued != numTasks {
+		t.Errorf("got %d enqueued, want %d", report.Enqueued, numTasks)
+	}
+
+	var flushes int64
+
+	for _, n := range report.FlushesByTrigger {
+		flushes += n
+	}
+
+	if flushes != stats.Batches {
+		t.Errorf("got %d flushes, buffer reports %d batches", flushes, stats.Batches)
+	}
+
+	if got := reporter.numBatches.Load(); got != stats.Batches {
+		t.Errorf("got %d batches recorded, buffer reports %d", got, stats.Batches)
+	}
+
+	var batches, tasks int64
+
+	for size, n := range report.BatchSizes {
+		batches += n
+		tasks += int64(size) * n
+	}
+
+	if batches != stats.Batches || tasks != numTasks {
+		t.Errorf("batch sizes cover %d batches of %d tasks, want %d batches of %d tasks", batches, tasks, stats.Batches, numTasks)
+	}
+
+	if report.MaxInFlight < 1 || report.MaxInFlight > 4 {
+		t.Errorf("got max in flight %d, want between 1 and 4", report.MaxInFlight)
+	}
+
+	if got := reporter.queueWait.Count(); got != numTasks {
+		t.Errorf("got %d queue wait samples, want %d", got, numTasks)
+	}
+
+	if got := reporter.dbLatency.Count(); got != numTasks {
+		t.Errorf("got %d db latency samples, want %d", got, numTasks)
+	}
+}
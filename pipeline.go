@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/abelanger5/postgres-fast-inserts/internal/dbsqlc"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var pipelineDepth int
+
+// pipelineStatementID numbers the statements prepared by pipelined connections. The
+// names are unique across the pool, since a connection keeps its statements after it
+// is released and may be acquired for a pipeline again.
+var pipelineStatementID atomic.Int64
+
+// pipelineStats samples the number of batches in flight on each pipelined connection
+type pipelineStats struct {
+	depth   int
+	samples int64
+	sum     int64
+	max     int
+}
+
+func (s pipelineStats) avg() float64 {
+	if s.samples == 0 {
+		return 0
+	}
+
+	return float64(s.sum) / float64(s.samples)
+}
+
+// pipelineRequest is a batch waiting to be sent on a pipeline
+type pipelineRequest struct {
+	tasks  []TaskParams
+	result chan pipelineResult
+
+	// prepares are the statements prepared in the same segment as the batch, in the
+	// order they were sent
+	prepares []preparedValues
+}
+
+// preparedValues is a named VALUES statement for a number of rows
+type preparedValues struct {
+	rows int
+	name string
+}
+
+type pipelineResult struct {
+	tasks []*dbsqlc.Task
+	err   error
+}
+
+// pipelineWriters holds one pipelined connection per writer. Each connection keeps up
+// to depth batches in flight, sending the next batch before the results of the
+// previous ones have been read.
+type pipelineWriters struct {
+	requests chan *pipelineRequest
+	depth    int
+	reporter *Reporter
+	wg       sync.WaitGroup
+}
+
+func startPipelineWriters(ctx context.Context, writers, depth int, reporter *Reporter) *pipelineWriters {
+	p := &pipelineWriters{
+		requests: make(chan *pipelineRequest),
+		depth:    depth,
+		reporter: reporter,
+	}
+
+	for i := 0; i < writers; i++ {
+		p.wg.Add(1)
+
+		go p.run(ctx)
+	}
+
+	return p
+}
+
+// insert sends the tasks on the next available connection and waits for the created
+// rows
+func (p *pipelineWriters) insert(ctx context.Context, tasks []TaskParams) ([]*dbsqlc.Task, error) {
+	req := &pipelineRequest{
+		tasks:  tasks,
+		result: make(chan pipelineResult, 1),
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case p.requests <- req:
+	}
+
+	// once a writer has accepted the request it always delivers a result
+	res := <-req.result
+
+	return res.tasks, res.err
+}
+
+// close waits for the writers to finish their outstanding batches. No more batches
+// may be inserted once close has been called.
+func (p *pipelineWriters) close() {
+	close(p.requests)
+	p.wg.Wait()
+}
+
+// pipelineConn is a connection in pipeline mode. pgconn pipelines bypass pgx's
+// statement cache and --exec-mode, so the connection prepares a named statement for
+// each number of rows itself.
+type pipelineConn struct {
+	conn     *pgxpool.Conn
+	pipeline *pgconn.Pipeline
+	typeMap  *pgtype.Map
+
+	// statements maps a number of rows to the name of its prepared VALUES statement
+	statements map[int]string
+}
+
+func (c *pipelineConn) release() {
+	c.pipeline.Close()
+	c.conn.Release()
+}
+
+func (p *pipelineWriters) run(ctx context.Context) {
+	defer p.wg.Done()
+
+	var conn *pipelineConn
+
+	// outstanding batches in the order they were sent, which is the order their
+	// results are returned in
+	outstanding := []*pipelineRequest{}
+	closed := false
+
+	// fail is called when the connection breaks, and fails every batch in flight
+	fail := func(err error) {
+		for _, req := range outstanding {
+			req.result <- pipelineResult{err: err}
+		}
+
+		outstanding = outstanding[:0]
+
+		conn.release()
+		conn = nil
+	}
+
+	defer func() {
+		if conn != nil {
+			conn.release()
+		}
+	}()
+
+	for !closed || len(outstanding) > 0 {
+		if !closed && len(outstanding) < p.depth {
+			var req *pipelineRequest
+			var ok, received bool
+
+			if len(outstanding) == 0 {
+				req, ok = <-p.requests
+				received = true
+			} else {
+				select {
+				case req, ok = <-p.requests:
+					received = true
+				default:
+				}
+			}
+
+			if received {
+				if !ok {
+					closed = true
+					continue
+				}
+
+				if conn == nil {
+					var err error
+
+					conn, err = startPipelineConn(ctx)
+
+					if err != nil {
+						req.result <- pipelineResult{err: err}
+						continue
+					}
+				}
+
+				if err := conn.send(req); err != nil {
+					outstanding = append(outstanding, req)
+					fail(err)
+					continue
+				}
+
+				outstanding = append(outstanding, req)
+				p.reporter.RecordPipelineDepth(len(outstanding))
+
+				continue
+			}
+		}
+
+		// the pipeline is full or no more batches are waiting, so wait for the oldest
+		req := outstanding[0]
+		outstanding = outstanding[1:]
+
+		res, err := conn.receive(req)
+
+		if err != nil {
+			outstanding = append([]*pipelineRequest{req}, outstanding...)
+			fail(err)
+			continue
+		}
+
+		req.result <- res
+	}
+}
+
+func startPipelineConn(ctx context.Context) (*pipelineConn, error) {
+	conn, err := pool.Acquire(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &pipelineConn{
+		conn:       conn,
+		pipeline:   conn.Conn().PgConn().StartPipeline(ctx),
+		typeMap:    pgtype.NewMap(),
+		statements: make(map[int]string),
+	}, nil
+}
+
+// send queues the tasks as multi-row VALUES statements followed by a sync point, so
+// that the batch runs in a single implicit transaction. A statement for a number of
+// rows which hasn't been used on this connection yet is prepared in the same segment.
+func (c *pipelineConn) send(req *pipelineRequest) error {
+	req.prepares = nil
+
+	for start := 0; start < len(req.tasks); start += maxValuesRows {
+		chunk := req.tasks[start:min(start+maxValuesRows, len(req.tasks))]
+		params := make([][]byte, 0, len(chunk)*valuesColumns)
+
+		for _, task := range chunk {
+			params = append(params, task.Args, []byte(task.IdempotencyKey.String))
+		}
+
+		name, ok := c.statements[len(chunk)]
+
+		if !ok {
+			name = fmt.Sprintf("pipeline_values_%d", pipelineStatementID.Add(1))
+
+			c.pipeline.SendPrepare(name, valuesStatement(len(chunk)), nil)
+			c.statements[len(chunk)] = name
+			req.prepares = append(req.prepares, preparedValues{rows: len(chunk), name: name})
+		}
+
+		c.pipeline.SendQueryPrepared(name, params, nil, nil)
+	}
+
+	return c.pipeline.Sync()
+}
+
+// forgetFailedPrepares removes the statements whose prepare didn't complete, so that
+// later batches prepare them again. described is the number of the request's prepares
+// which the server described before the batch failed.
+func (c *pipelineConn) forgetFailedPrepares(req *pipelineRequest, described int) {
+	for _, p := range req.prepares[described:] {
+		if c.statements[p.rows] == p.name {
+			delete(c.statements, p.rows)
+		}
+	}
+}
+
+// receive reads the results of the oldest batch up to and including its sync point.
+// An error means that the connection is broken, while a failed batch is reported in
+// the result.
+func (c *pipelineConn) receive(req *pipelineRequest) (pipelineResult, error) {
+	tasks := req.tasks
+	created := make([]*dbsqlc.Task, 0, len(tasks))
+
+	var batchErr error
+
+	// described counts the batch's prepares which succeeded
+	described := 0
+
+	// pgErrOrBroken records errors returned by the server for this batch, and returns
+	// any other error, which means the connection can't be used any more
+	pgErrOrBroken := func(err error) error {
+		var pgErr *pgconn.PgError
+
+		if !errors.As(err, &pgErr) {
+			return err
+		}
+
+		if batchErr == nil {
+			batchErr = err
+		}
+
+		return nil
+	}
+
+	for {
+		res, err := c.pipeline.GetResults()
+
+		if err != nil {
+			// the server skips the rest of the batch and then sends the sync point
+			if err := pgErrOrBroken(err); err != nil {
+				return pipelineResult{}, err
+			}
+
+			continue
+		}
+
+		switch r := res.(type) {
+		case *pgconn.StatementDescription:
+			described++
+		case *pgconn.ResultReader:
+			fields := r.FieldDescriptions()
+
+			for r.NextRow() {
+				var t dbsqlc.Task
+
+				dst := []any{&t.ID, &t.CreatedAt, &t.Args, &t.IdempotencyKey}
+
+				for i, v := range r.Values() {
+					if err := c.typeMap.Scan(fields[i].DataTypeOID, fields[i].Format, v, dst[i]); err != nil && batchErr == nil {
+						batchErr = err
+					}
+				}
+
+				created = append(created, &t)
+			}
+
+			if _, err := r.Close(); err != nil {
+				if err := pgErrOrBroken(err); err != nil {
+					return pipelineResult{}, err
+				}
+			}
+		case *pgconn.PipelineSync:
+			if batchErr != nil {
+				c.forgetFailedPrepares(req, described)
+
+				return pipelineResult{err: fmt.Errorf("could not create tasks pipeline: %w", batchErr)}, nil
+			}
+
+			resTasks, err := orderByKey(tasks, created)
+
+			if err != nil {
+				return pipelineResult{err: fmt.Errorf("could not create tasks pipeline: %w", err)}, nil
+			}
+
+			return pipelineResult{tasks: resTasks}, nil
+		case nil:
+			return pipelineResult{}, errors.New("could not create tasks pipeline: no results for batch")
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestForgetFailedPrepares(t *testing.T) {
+	tests := []struct {
+		name      string
+		described int
+		want      map[int]string
+	}{
+		{
+			name:      "every prepare succeeded",
+			described: 2,
+			want:      map[int]string{10: "a", 500: "b", 1: "c"},
+		},
+		{
+			name:      "second prepare failed",
+			described: 1,
+			want:      map[int]string{10: "a", 1: "c"},
+		},
+		{
+			name:      "every prepare failed",
+			described: 0,
+			want:      map[int]string{1: "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &pipelineConn{statements: map[int]string{10: "a", 500: "b", 1: "c"}}
+
+			c.forgetFailedPrepares(&pipelineRequest{
+				prepares: []preparedValues{{rows: 10, name: "a"}, {rows: 500, name: "b"}},
+			}, tt.described)
+
+			if fmt.Sprint(c.statements) != fmt.Sprint(tt.want) {
+				t.Fatalf("got %v, want %v", c.statements, tt.want)
+			}
+		})
+	}
+}
+
+// TestForgetFailedPreparesKeepsNewerStatement checks that a failed prepare doesn't
+// remove a statement which a later batch has prepared again under a new name
+func TestForgetFailedPreparesKeepsNewerStatement(t *testing.T) {
+	c := &pipelineConn{statements: map[int]string{10: "newer"}}
+
+	c.forgetFailedPrepares(&pipelineRequest{prepares: []preparedValues{{rows: 10, name: "older"}}}, 0)
+
+	if c.statements[10] != "newer" {
+		t.Fatalf("got %v, want the newer statement to be kept", c.statements)
+	}
+}
@@ -0,0 +1,70 @@
+package buffer
+
+import (
+	"sync"
+	"time"
+)
+
+// adaptiveLimits tunes the batch size and linger time of a Buffer at runtime using
+// additive-increase/multiplicative-decrease against a write latency target.
+type adaptiveLimits struct {
+	mu sync.Mutex
+
+	batchSize int
+	linger    time.Duration
+
+	minBatchSize int
+	maxBatchSize int
+	step         int
+
+	minLinger time.Duration
+	maxLinger time.Duration
+
+	target time.Duration
+}
+
+func newAdaptiveLimits(initialBatchSize, maxBatchSize int, initialLinger, target time.Duration) *adaptiveLimits {
+	step := initialBatchSize / 10
+
+	if step < 1 {
+		step = 1
+	}
+
+	maxLinger := max(initialLinger, target)
+
+	return &adaptiveLimits{
+		batchSize:    initialBatchSize,
+		linger:       initialLinger,
+		minBatchSize: 1,
+		maxBatchSize: maxBatchSize,
+		step:         step,
+		minLinger:    min(initialLinger, time.Millisecond),
+		maxLinger:    maxLinger,
+		target:       target,
+	}
+}
+
+func (a *adaptiveLimits) get() (int, time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.batchSize, a.linger
+}
+
+// observe adjusts the limits after a write of n tasks, where limit was the batch size
+// in effect when the batch was read from the buffer.
+func (a *adaptiveLimits) observe(n, limit int, latency time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch {
+	case latency > a.target:
+		// the database is falling behind: halve the batch size and flush less often
+		a.batchSize = max(a.minBatchSize, a.batchSize/2)
+		a.linger = min(a.maxLinger, a.linger*2)
+	case n >= limit:
+		// the batch was full so the queue is backing up: grow the batch and flush sooner
+		a.batchSize = min(a.maxBatchSize, a.batchSize+a.step)
+		a.linger = max(a.minLinger, a.linger/2)
+	}
+}
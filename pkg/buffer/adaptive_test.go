@@ -0,0 +1,111 @@
+package buffer
+
+import (
+	"testing"
+	"time"
+)
+
+// observation is a single write seen by adaptiveLimits.observe
+type observation struct {
+	n, limit int
+	latency  time.Duration
+}
+
+func repeat(o observation, count int) []observation {
+	obs := make([]observation, count)
+
+	for i := range obs {
+		obs[i] = o
+	}
+
+	return obs
+}
+
+func TestAdaptiveLimits(t *testing.T) {
+	var (
+		slow    = observation{n: 100, limit: 100, latency: 60 * time.Millisecond}
+		full    = observation{n: 100, limit: 100, latency: 10 * time.Millisecond}
+		partial = observation{n: 40, limit: 100, latency: 10 * time.Millisecond}
+	)
+
+	tests := []struct {
+		name             string
+		initialBatchSize int
+		observations     []observation
+		wantBatchSize    int
+		wantLinger       time.Duration
+	}{
+		{
+			name:             "slow write halves the batch size and doubles linger",
+			initialBatchSize: 100,
+			observations:     []observation{slow},
+			wantBatchSize:    50,
+			wantLinger:       20 * time.Millisecond,
+		},
+		{
+			name:             "slow writes clamp to the min batch size and max linger",
+			initialBatchSize: 100,
+			observations:     repeat(slow, 10),
+			wantBatchSize:    1,
+			wantLinger:       50 * time.Millisecond,
+		},
+		{
+			name:             "full batch grows the batch size and halves linger",
+			initialBatchSize: 100,
+			observations:     []observation{full},
+			wantBatchSize:    110,
+			wantLinger:       5 * time.Millisecond,
+		},
+		{
+			name:             "full batches clamp to the max batch size and min linger",
+			initialBatchSize: 100,
+			observations:     repeat(full, 20),
+			wantBatchSize:    200,
+			wantLinger:       time.Millisecond,
+		},
+		{
+			name:             "latency at the target is not slow",
+			initialBatchSize: 100,
+			observations:     []observation{{n: 100, limit: 100, latency: 50 * time.Millisecond}},
+			wantBatchSize:    110,
+			wantLinger:       5 * time.Millisecond,
+		},
+		{
+			name:             "partial batch under the target leaves the limits alone",
+			initialBatchSize: 100,
+			observations:     []observation{partial},
+			wantBatchSize:    100,
+			wantLinger:       10 * time.Millisecond,
+		},
+		{
+			name:             "small batches grow by at least one",
+			initialBatchSize: 5,
+			observations:     []observation{{n: 5, limit: 5, latency: time.Millisecond}},
+			wantBatchSize:    6,
+			wantLinger:       5 * time.Millisecond,
+		},
+		{
+			name:             "slow write recovers once batches fill again",
+			initialBatchSize: 100,
+			observations:     []observation{slow, full, full},
+			wantBatchSize:    70,
+			wantLinger:       5 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAdaptiveLimits(tt.initialBatchSize, 200, 10*time.Millisecond, 50*time.Millisecond)
+
+			for _, o := range tt.observations {
+				a.observe(o.n, o.limit, o.latency)
+			}
+
+			batchSize, linger := a.get()
+
+			if batchSize != tt.wantBatchSize || linger != tt.wantLinger {
+				t.Fatalf("got (%d, %s), want (%d, %s)", batchSize, linger, tt.wantBatchSize, tt.wantLinger)
+			}
+		})
+	}
+}
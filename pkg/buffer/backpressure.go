@@ -0,0 +1,188 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// BackpressurePolicy decides what a write does when the buffer is full
+type BackpressurePolicy int
+
+const (
+	// BackpressureTimeout waits for up to Options.EnqueueTimeout and then fails the
+	// write with ErrEnqueueTimeout or ErrMemoryLimit
+	BackpressureTimeout BackpressurePolicy = iota
+
+	// BackpressureBlock waits until there is space or the write's context is done
+	BackpressureBlock
+
+	// BackpressureReject fails the write immediately with ErrFull
+	BackpressureReject
+
+	// BackpressureDropOldest makes space by failing the oldest queued tasks with ErrShed
+	BackpressureDropOldest
+)
+
+var backpressurePolicies = map[string]BackpressurePolicy{
+	"timeout":     BackpressureTimeout,
+	"block":       BackpressureBlock,
+	"reject":      BackpressureReject,
+	"drop-oldest": BackpressureDropOldest,
+}
+
+// ParseBackpressurePolicy parses the name of a policy: timeout, block, reject or
+// drop-oldest
+func ParseBackpressurePolicy(name string) (BackpressurePolicy, error) {
+	policy, ok := backpressurePolicies[name]
+
+	if !ok {
+		return 0, fmt.Errorf("unknown backpressure policy %q", name)
+	}
+
+	return policy, nil
+}
+
+func (p BackpressurePolicy) String() string {
+	for name, policy := range backpressurePolicies {
+		if policy == p {
+			return name
+		}
+	}
+
+	return fmt.Sprintf("BackpressurePolicy(%d)", int(p))
+}
+
+// IsRejected reports whether err means that a write was refused because the buffer
+// was full, as opposed to the buffer being closed or the caller giving up
+func IsRejected(err error) bool {
+	return errors.Is(err, ErrFull) || errors.Is(err, ErrEnqueueTimeout) || errors.Is(err, ErrMemoryLimit)
+}
+
+// enqueue reserves memory for the task and adds it to the buffer, applying the
+// backpressure policy when either is full
+func (b *Buffer[I, O]) enqueue(ctx context.Context, t *TaskWithErrCh[I, O]) error {
+	acquired := b.tryAcquireBytes(t)
+
+	if acquired {
+		select {
+		case b.bufferCh <- t:
+			return nil
+		default:
+		}
+	}
+
+	if b.opts.Backpressure == BackpressureReject {
+		if acquired {
+			b.releaseBytes(t)
+		}
+
+		return ErrFull
+	}
+
+	waitCtx, cancel := b.waitContext(ctx)
+	defer cancel()
+
+	if !acquired {
+		if err := b.acquireBytes(waitCtx, t); err != nil {
+			return b.waitErr(ctx, ErrMemoryLimit)
+		}
+	}
+
+	if err := b.send(waitCtx, t); err != nil {
+		b.releaseBytes(t)
+		return b.waitErr(ctx, ErrEnqueueTimeout)
+	}
+
+	return nil
+}
+
+// waitContext returns a context which is done when ctx is done, the buffer is closed
+// or, under BackpressureTimeout, the enqueue timeout has passed
+func (b *Buffer[I, O]) waitContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	var cancel context.CancelFunc
+
+	if b.opts.Backpressure == BackpressureTimeout {
+		ctx, cancel = context.WithTimeout(ctx, b.opts.EnqueueTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	stop := context.AfterFunc(b.closeCtx, cancel)
+
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// waitErr explains why waiting for space stopped early
+func (b *Buffer[I, O]) waitErr(callerCtx context.Context, timeoutErr error) error {
+	if b.closeCtx.Err() != nil {
+		return ErrClosed
+	}
+
+	if err := callerCtx.Err(); err != nil {
+		return err
+	}
+
+	return timeoutErr
+}
+
+func (b *Buffer[I, O]) tryAcquireBytes(t *TaskWithErrCh[I, O]) bool {
+	return b.bufferedBytes == nil || b.bufferedBytes.TryAcquire(b.weight(t))
+}
+
+// acquireBytes reserves the task's size against MaxBufferedBytes, waiting until ctx is
+// done
+func (b *Buffer[I, O]) acquireBytes(ctx context.Context, t *TaskWithErrCh[I, O]) error {
+	if b.opts.Backpressure == BackpressureDropOldest {
+		for !b.bufferedBytes.TryAcquire(b.weight(t)) {
+			if !b.shedOldest() {
+				// the remaining memory is held by tasks which are still being enqueued
+				return b.bufferedBytes.Acquire(ctx, b.weight(t))
+			}
+		}
+
+		return nil
+	}
+
+	return b.bufferedBytes.Acquire(ctx, b.weight(t))
+}
+
+func (b *Buffer[I, O]) send(ctx context.Context, t *TaskWithErrCh[I, O]) error {
+	if b.opts.Backpressure == BackpressureDropOldest {
+		for {
+			select {
+			case b.bufferCh <- t:
+				return nil
+			default:
+			}
+
+			if !b.shedOldest() && ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+	}
+
+	select {
+	case b.bufferCh <- t:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shedOldest fails the oldest queued task with ErrShed, returning false if the buffer
+// is empty
+func (b *Buffer[I, O]) shedOldest() bool {
+	select {
+	case msg := <-b.bufferCh:
+		b.releaseBytes(msg)
+		b.shed.Add(1)
+		b.fail(msg, ErrShed)
+		return true
+	default:
+		return false
+	}
+}
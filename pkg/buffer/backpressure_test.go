@@ -0,0 +1,153 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackpressure(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy BackpressurePolicy
+		opts   Options[int]
+
+		// ctxTimeout is the timeout of the context passed to the write which finds the
+		// buffer full, or zero for no timeout
+		ctxTimeout time.Duration
+
+		wantErr      error
+		wantRejected int64
+		wantShed     int64
+	}{
+		{
+			name:         "timeout",
+			policy:       BackpressureTimeout,
+			opts:         Options[int]{EnqueueTimeout: 20 * time.Millisecond},
+			wantErr:      ErrEnqueueTimeout,
+			wantRejected: 1,
+		},
+		{
+			name:   "timeout on memory",
+			policy: BackpressureTimeout,
+			opts: Options[int]{
+				ChannelCapacity:  10,
+				EnqueueTimeout:   20 * time.Millisecond,
+				SizeOf:           func(int) int { return 10 },
+				MaxBufferedBytes: 20,
+			},
+			wantErr:      ErrMemoryLimit,
+			wantRejected: 1,
+		},
+		{
+			name:       "block until the caller gives up",
+			policy:     BackpressureBlock,
+			ctxTimeout: 20 * time.Millisecond,
+			wantErr:    context.DeadlineExceeded,
+		},
+		{
+			name:         "reject",
+			policy:       BackpressureReject,
+			wantErr:      ErrFull,
+			wantRejected: 1,
+		},
+		{
+			name:     "drop oldest",
+			policy:   BackpressureDropOldest,
+			wantShed: 1,
+		},
+		{
+			name:   "drop oldest on memory",
+			policy: BackpressureDropOldest,
+			opts: Options[int]{
+				ChannelCapacity:  10,
+				SizeOf:           func(int) int { return 10 },
+				MaxBufferedBytes: 20,
+			},
+			wantShed: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gate := newGatedWrite(echo)
+
+			opts := tt.opts
+			opts.MaxBatchSize = 1
+			opts.MaxConcurrentFlushes = 1
+			opts.Linger = time.Millisecond
+			opts.Backpressure = tt.policy
+
+			if opts.ChannelCapacity == 0 {
+				opts.ChannelCapacity = 2
+			}
+
+			b := New(context.Background(), opts, gate.Write)
+
+			// task 0 holds the only flush slot, and tasks 1 and 2 fill the buffer
+			results := writeAll(t, b, []int{0})
+
+			<-gate.started
+
+			results = append(results, writeAll(t, b, []int{1, 2})...)
+
+			ctx := context.Background()
+
+			if tt.ctxTimeout > 0 {
+				var cancel context.CancelFunc
+
+				ctx, cancel = context.WithTimeout(ctx, tt.ctxTimeout)
+				defer cancel()
+			}
+
+			ch := make(chan result, 1)
+
+			err := b.WriteWithCallback(ctx, 3, func(out *int, err error) {
+				ch <- result{out, err}
+			})
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("write to a full buffer: got %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantShed > 0 {
+				if r := waitResult(t, results[1]); !errors.Is(r.err, ErrShed) {
+					t.Fatalf("oldest queued task: got %v, want ErrShed", r.err)
+				}
+			}
+
+			close(gate.release)
+
+			if err := b.Close(context.Background()); err != nil {
+				t.Fatalf("Close returned an error: %v", err)
+			}
+
+			if err == nil {
+				if r := waitResult(t, ch); r.err != nil {
+					t.Fatalf("accepted task: got %v, want success", r.err)
+				}
+			}
+
+			stats := b.Stats()
+
+			if stats.Rejected != tt.wantRejected || stats.Shed != tt.wantShed {
+				t.Fatalf("got %d rejected and %d shed, want %d and %d", stats.Rejected, stats.Shed, tt.wantRejected, tt.wantShed)
+			}
+		})
+	}
+}
+
+func TestParseBackpressurePolicy(t *testing.T) {
+	for name, want := range backpressurePolicies {
+		got, err := ParseBackpressurePolicy(name)
+
+		if err != nil || got != want || got.String() != name {
+			t.Fatalf("ParseBackpressurePolicy(%q) = %v, %v", name, got, err)
+		}
+	}
+
+	if _, err := ParseBackpressurePolicy("unknown"); err == nil {
+		t.Fatal("expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,729 @@
+// Package buffer batches individual writes into bulk writes which are flushed by a
+// bounded number of concurrent flushers.
+package buffer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+var (
+	// ErrClosed is returned when writing to a buffer that has been closed
+	ErrClosed = errors.New("buffer is closed")
+
+	// ErrDrainTimeout is returned to tasks which were still queued when the context
+	// passed to Close was done
+	ErrDrainTimeout = errors.New("buffer closed before the task could be flushed")
+
+	// ErrResultCount is returned when write returns a different number of results than
+	// the number of tasks it was given
+	ErrResultCount = errors.New("number of tasks out does not match number of tasks in")
+
+	// ErrMemoryLimit is returned when a task could not be added because the buffer held
+	// MaxBufferedBytes for longer than Options.EnqueueTimeout
+	ErrMemoryLimit = errors.New("timeout waiting for buffer memory")
+
+	// ErrFull is returned by BackpressureReject when the buffer is full
+	ErrFull = errors.New("buffer is full")
+
+	// ErrShed is returned to queued tasks which were dropped by BackpressureDropOldest
+	ErrShed = errors.New("task was shed from a full buffer")
+
+	// ErrEnqueueTimeout is returned when a task could not be added to a full buffer
+	// within Options.EnqueueTimeout
+	ErrEnqueueTimeout = errors.New("timeout while writing to buffer")
+)
+
+// Options configures a Buffer. Zero values are replaced with the defaults below.
+type Options[I any] struct {
+	// MaxBatchSize is the maximum number of tasks passed to a single write. Defaults
+	// to 100.
+	MaxBatchSize int
+
+	// MaxConcurrentFlushes is the maximum number of writes in flight at once. Defaults
+	// to 1.
+	MaxConcurrentFlushes int
+
+	// Linger is both the interval at which the buffer is flushed and the minimum time
+	// a flush holds its concurrency slot. Defaults to 10ms.
+	Linger time.Duration
+
+	// EnqueueTimeout is how long WriteNoWait waits for space in a full buffer under
+	// BackpressureTimeout. Defaults to 10s.
+	EnqueueTimeout time.Duration
+
+	// ChannelCapacity is the number of tasks which can be queued before writers
+	// block. Defaults to MaxBatchSize * MaxConcurrentFlushes.
+	ChannelCapacity int
+
+	// TargetLatency enables adaptive batching when set: the batch size and linger
+	// time are adjusted at runtime to keep write latency under this target. The batch
+	// size stays between 1 and MaxBatchSize, and the linger time between 1ms (or
+	// Linger, if lower) and the larger of Linger and TargetLatency.
+	TargetLatency time.Duration
+
+	// BisectFailures splits a batch whose write failed into halves and retries each
+	// recursively, so that only the tasks which cause the failure receive the error.
+	// Errors which the retry policy considers transient and context errors are not
+	// caused by particular tasks, so they fail the whole batch instead.
+	BisectFailures bool
+
+	// Retry is applied to each write before the batch is failed or bisected. If nil,
+	// writes are not retried.
+	Retry *RetryPolicy
+
+	// SizeOf returns the size of a task in bytes. It is required for MaxBatchBytes and
+	// MaxBufferedBytes, and is used to report bytes per batch in Stats.
+	SizeOf func(I) int
+
+	// MaxBatchBytes caps the total size of the tasks passed to a single write. A task
+	// larger than the cap is written in a batch of its own. Zero means no limit.
+	MaxBatchBytes int
+
+	// MaxBufferedBytes caps the total size of the tasks waiting in the buffer. While
+	// the buffer is over budget, writers are handled according to Backpressure. Zero
+	// means no limit.
+	MaxBufferedBytes int
+
+	// Backpressure decides what happens to writes when the buffer is full. Defaults to
+	// BackpressureTimeout.
+	Backpressure BackpressurePolicy
+
+	// Observer receives events about the buffer's internals. Defaults to NoopObserver.
+	Observer Observer
+}
+
+func (o Options[I]) withDefaults() Options[I] {
+	if o.MaxBatchSize <= 0 {
+		o.MaxBatchSize = 100
+	}
+
+	if o.MaxConcurrentFlushes <= 0 {
+		o.MaxConcurrentFlushes = 1
+	}
+
+	if o.Linger <= 0 {
+		o.Linger = 10 * time.Millisecond
+	}
+
+	if o.EnqueueTimeout <= 0 {
+		o.EnqueueTimeout = 10 * time.Second
+	}
+
+	if o.ChannelCapacity <= 0 {
+		o.ChannelCapacity = o.MaxBatchSize * o.MaxConcurrentFlushes
+	}
+
+	if o.Observer == nil {
+		o.Observer = NoopObserver{}
+	}
+
+	return o
+}
+
+// Stats contains counters collected over the lifetime of a Buffer
+type Stats struct {
+	// Bisections is the number of times a failed batch was split in two
+	Bisections int64
+
+	// Retries is the number of times a write was retried, and RetryTime is the total
+	// time spent from the first failure of a write until its last retry returned
+	Retries   int64
+	RetryTime time.Duration
+
+	// Batches is the number of batches flushed, BatchBytes is their total size and
+	// MaxBatchBytes is the size of the largest one. Sizes are only tracked when
+	// Options.SizeOf is set.
+	Batches       int64
+	BatchBytes    int64
+	MaxBatchBytes int64
+
+	// Rejected is the number of writes which failed to enqueue their task because the
+	// buffer was full, and Shed is the number of queued tasks dropped by
+	// BackpressureDropOldest
+	Rejected int64
+	Shed     int64
+}
+
+type TaskWithErrCh[I, O any] struct {
+	task I
+	size int
+
+	enqueuedAt time.Time
+	flushedAt  time.Time
+
+	// writeDuration is the duration of the write which delivered the result
+	writeDuration time.Duration
+
+	// either callback is set, or resultCh and errCh are
+	resultCh chan *O
+	errCh    chan error
+	callback func(*O, error)
+}
+
+func (t *TaskWithErrCh[I, O]) GetResult() (*O, error) {
+	select {
+	case err := <-t.errCh:
+		return nil, err
+	case result := <-t.resultCh:
+		return result, nil
+	}
+}
+
+type Buffer[I, O any] struct {
+	ctx  context.Context
+	opts Options[I]
+
+	bufferCh chan *TaskWithErrCh[I, O]
+	notifier chan struct{}
+
+	// carry holds tasks which were read from bufferCh but did not fit in a batch under
+	// MaxBatchBytes. Each flush puts back at most one task, so it never blocks.
+	carry chan *TaskWithErrCh[I, O]
+
+	// bufferedBytes is nil unless MaxBufferedBytes is set
+	bufferedBytes *semaphore.Weighted
+
+	semaphore chan struct{}
+
+	// adaptive is nil unless adaptive batching is enabled
+	adaptive *adaptiveLimits
+
+	// closeCtx is canceled when Close is called, and writers tracks WriteNoWait calls
+	// which started before that
+	closeCtx  context.Context
+	close     context.CancelFunc
+	closeOnce sync.Once
+	closedMu  sync.RWMutex
+	closed    bool
+	writers   sync.WaitGroup
+
+	// abandoned is set when Close gives up on draining the buffer
+	abandoned atomic.Bool
+
+	bisections atomic.Int64
+	retries    atomic.Int64
+	retryTime  atomic.Int64
+
+	batches       atomic.Int64
+	batchBytes    atomic.Int64
+	maxBatchBytes atomic.Int64
+
+	rejected atomic.Int64
+	shed     atomic.Int64
+
+	write func(task []I) ([]*O, error)
+}
+
+// New creates a buffer which flushes tasks through write until ctx is done or Close
+// is called.
+func New[I, O any](
+	ctx context.Context,
+	opts Options[I],
+	write func(task []I) ([]*O, error),
+) *Buffer[I, O] {
+	opts = opts.withDefaults()
+
+	b := &Buffer[I, O]{
+		ctx:       ctx,
+		opts:      opts,
+		bufferCh:  make(chan *TaskWithErrCh[I, O], opts.ChannelCapacity),
+		notifier:  make(chan struct{}, 1), // buffered to notify even when a flush is in progress
+		write:     write,
+		semaphore: make(chan struct{}, opts.MaxConcurrentFlushes),
+		carry:     make(chan *TaskWithErrCh[I, O], opts.MaxConcurrentFlushes),
+	}
+
+	b.closeCtx, b.close = context.WithCancel(context.Background())
+
+	if opts.MaxBufferedBytes > 0 && opts.SizeOf != nil {
+		b.bufferedBytes = semaphore.NewWeighted(int64(opts.MaxBufferedBytes))
+	}
+
+	if opts.TargetLatency > 0 {
+		b.adaptive = newAdaptiveLimits(opts.MaxBatchSize, opts.MaxBatchSize, opts.Linger, opts.TargetLatency)
+	}
+
+	b.startFlusher(ctx)
+
+	return b
+}
+
+// Limits returns the batch size and linger time currently used by the flusher.
+func (b *Buffer[I, O]) Limits() (int, time.Duration) {
+	if b.adaptive != nil {
+		return b.adaptive.get()
+	}
+
+	return b.opts.MaxBatchSize, b.opts.Linger
+}
+
+// QueueDepth returns the number of tasks waiting to be flushed.
+func (b *Buffer[I, O]) QueueDepth() int {
+	return len(b.bufferCh) + len(b.carry)
+}
+
+// Stats returns a snapshot of the buffer's counters.
+func (b *Buffer[I, O]) Stats() Stats {
+	return Stats{
+		Bisections: b.bisections.Load(),
+		Retries:    b.retries.Load(),
+		RetryTime:  time.Duration(b.retryTime.Load()),
+
+		Batches:       b.batches.Load(),
+		BatchBytes:    b.batchBytes.Load(),
+		MaxBatchBytes: b.maxBatchBytes.Load(),
+
+		Rejected: b.rejected.Load(),
+		Shed:     b.shed.Load(),
+	}
+}
+
+func (b *Buffer[I, O]) WriteNoWait(task I) (*TaskWithErrCh[I, O], error) {
+	return b.WriteNoWaitContext(context.Background(), task)
+}
+
+// WriteNoWaitContext adds a task to the buffer without waiting for it to be written.
+// When the buffer is full, the Backpressure policy decides whether to wait, reject the
+// task or shed the oldest task; waiting stops early when ctx is done.
+func (b *Buffer[I, O]) WriteNoWaitContext(ctx context.Context, task I) (*TaskWithErrCh[I, O], error) {
+	taskWithErrCh := &TaskWithErrCh[I, O]{
+		task: task,
+		// buffered so that the flusher never blocks on a caller which stopped waiting
+		resultCh: make(chan *O, 1),
+		errCh:    make(chan error, 1),
+	}
+
+	if err := b.submit(ctx, taskWithErrCh); err != nil {
+		return nil, err
+	}
+
+	return taskWithErrCh, nil
+}
+
+// WriteWithCallback adds a task to the buffer and calls done with its result once it
+// has been written. Unlike WriteNoWait, it allocates no channels and the caller does
+// not need a goroutine to wait for the result. done is called from the flusher, so it
+// must return quickly. If the task could not be added, done is not called and the
+// error is returned.
+func (b *Buffer[I, O]) WriteWithCallback(ctx context.Context, task I, done func(*O, error)) error {
+	return b.submit(ctx, &TaskWithErrCh[I, O]{
+		task:     task,
+		callback: done,
+	})
+}
+
+func (b *Buffer[I, O]) submit(ctx context.Context, taskWithErrCh *TaskWithErrCh[I, O]) error {
+	b.closedMu.RLock()
+
+	if b.closed {
+		b.closedMu.RUnlock()
+		return ErrClosed
+	}
+
+	b.writers.Add(1)
+	b.closedMu.RUnlock()
+
+	defer b.writers.Done()
+
+	if b.opts.SizeOf != nil {
+		taskWithErrCh.size = b.opts.SizeOf(taskWithErrCh.task)
+	}
+
+	taskWithErrCh.enqueuedAt = time.Now()
+
+	if err := b.enqueue(ctx, taskWithErrCh); err != nil {
+		if IsRejected(err) {
+			b.rejected.Add(1)
+		}
+
+		return err
+	}
+
+	b.opts.Observer.OnEnqueue(len(b.bufferCh))
+
+	// notify the flusher that there is a new message
+	select {
+	case b.notifier <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (b *Buffer[I, O]) releaseBytes(t *TaskWithErrCh[I, O]) {
+	if b.bufferedBytes != nil {
+		b.bufferedBytes.Release(b.weight(t))
+	}
+}
+
+// weight clamps a task's size so that a task larger than MaxBufferedBytes can still be
+// buffered on its own
+func (b *Buffer[I, O]) weight(t *TaskWithErrCh[I, O]) int64 {
+	return int64(min(max(t.size, 1), b.opts.MaxBufferedBytes))
+}
+
+func (b *Buffer[I, O]) Write(task I) (*O, error) {
+	taskWithErrCh, err := b.WriteNoWait(task)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return taskWithErrCh.GetResult()
+}
+
+// Close stops accepting new writes and flushes every task remaining in the buffer. If
+// ctx is done before the buffer is drained, the remaining tasks fail with
+// ErrDrainTimeout and the context error is returned.
+func (b *Buffer[I, O]) Close(ctx context.Context) error {
+	b.closeOnce.Do(func() {
+		b.closedMu.Lock()
+		b.closed = true
+		b.close()
+		b.closedMu.Unlock()
+	})
+
+	// wait for in-progress writes to either enqueue their task or give up
+	b.writers.Wait()
+
+	for {
+		for len(b.bufferCh) > 0 || len(b.carry) > 0 {
+			select {
+			case b.semaphore <- struct{}{}:
+				go b.flushBatch(FlushTriggerClose)
+			case <-ctx.Done():
+				b.abandon()
+				return fmt.Errorf("could not drain buffer: %w", ctx.Err())
+			}
+		}
+
+		if err := b.waitForFlushes(ctx); err != nil {
+			b.abandon()
+			return fmt.Errorf("could not wait for in-flight flushes: %w", err)
+		}
+
+		// a flush which was running during the check above may have carried a task over
+		if len(b.bufferCh) == 0 && len(b.carry) == 0 {
+			return nil
+		}
+	}
+}
+
+// waitForFlushes waits until no flush is in flight. Holding every semaphore slot means
+// that all in-flight flushes have finished.
+func (b *Buffer[I, O]) waitForFlushes(ctx context.Context) error {
+	for i := 0; i < cap(b.semaphore); i++ {
+		select {
+		case b.semaphore <- struct{}{}:
+		case <-ctx.Done():
+			for ; i > 0; i-- {
+				<-b.semaphore
+			}
+
+			return ctx.Err()
+		}
+	}
+
+	for i := 0; i < cap(b.semaphore); i++ {
+		<-b.semaphore
+	}
+
+	return nil
+}
+
+// abandon fails the tasks left in the buffer once Close has given up on draining it.
+// Flushes which are still in flight fail any task they carry over afterwards.
+func (b *Buffer[I, O]) abandon() {
+	b.abandoned.Store(true)
+	b.failRemaining(ErrDrainTimeout)
+}
+
+func (b *Buffer[I, O]) failRemaining(err error) {
+	for {
+		select {
+		case msg := <-b.carry:
+			b.fail(msg, err)
+		case msg := <-b.bufferCh:
+			b.releaseBytes(msg)
+			b.fail(msg, err)
+		default:
+			return
+		}
+	}
+}
+
+// next returns the next task to flush, preferring tasks carried over from a previous
+// batch, or nil if there are none
+func (b *Buffer[I, O]) next() *TaskWithErrCh[I, O] {
+	select {
+	case msg := <-b.carry:
+		return msg
+	default:
+	}
+
+	select {
+	case msg := <-b.bufferCh:
+		b.releaseBytes(msg)
+		msg.flushedAt = time.Now()
+		return msg
+	default:
+		return nil
+	}
+}
+
+func (b *Buffer[I, O]) succeed(msg *TaskWithErrCh[I, O], result *O) {
+	b.observeResult(msg, nil)
+
+	if msg.callback != nil {
+		msg.callback(result, nil)
+		return
+	}
+
+	msg.resultCh <- result
+}
+
+func (b *Buffer[I, O]) fail(msg *TaskWithErrCh[I, O], err error) {
+	b.observeResult(msg, err)
+
+	if msg.callback != nil {
+		msg.callback(nil, err)
+		return
+	}
+
+	msg.errCh <- err
+}
+
+func (b *Buffer[I, O]) observeResult(msg *TaskWithErrCh[I, O], err error) {
+	e := ResultEvent{
+		Write: msg.writeDuration,
+		Total: time.Since(msg.enqueuedAt),
+		Err:   err,
+	}
+
+	if !msg.flushedAt.IsZero() {
+		e.QueueWait = msg.flushedAt.Sub(msg.enqueuedAt)
+	}
+
+	b.opts.Observer.OnResult(e)
+}
+
+func (b *Buffer[I, O]) startFlusher(ctx context.Context) {
+	linger := b.opts.Linger
+	ticker := time.NewTicker(linger)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				b.flush(FlushTriggerShutdown)
+				return
+			case <-b.closeCtx.Done():
+				// Close takes over flushing the remaining tasks
+				return
+			case <-ticker.C:
+				// follow the linger time chosen by adaptive batching
+				if _, current := b.Limits(); current != linger {
+					linger = current
+					ticker.Reset(linger)
+				}
+
+				go b.flush(FlushTriggerTicker)
+			case <-b.notifier:
+				go b.flush(FlushTriggerNotifier)
+			}
+		}
+	}()
+}
+
+func (b *Buffer[I, O]) flush(trigger FlushTrigger) {
+	wg := sync.WaitGroup{}
+
+outer:
+	for i := 0; i < b.opts.MaxConcurrentFlushes; i++ {
+		select {
+		case b.semaphore <- struct{}{}:
+		default:
+			if i == 0 {
+				b.opts.Observer.OnSaturated(trigger)
+			}
+
+			break outer
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			b.flushBatch(trigger)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// flushBatch writes a single batch from the buffer. The caller must hold a semaphore
+// slot, which is released once the linger time has passed.
+func (b *Buffer[I, O]) flushBatch(trigger FlushTrigger) {
+	startedFlush := time.Now()
+	maxBatch, linger := b.Limits()
+
+	defer func() {
+		go func() {
+			<-time.After(linger - time.Since(startedFlush))
+			<-b.semaphore
+		}()
+	}()
+
+	msgsWithChs := make([]*TaskWithErrCh[I, O], 0)
+	batchBytes := 0
+
+	// read all messages currently in the buffer
+	for i := 0; i < maxBatch; i++ {
+		msg := b.next()
+
+		if msg == nil {
+			break
+		}
+
+		if b.opts.MaxBatchBytes > 0 && len(msgsWithChs) > 0 && batchBytes+msg.size > b.opts.MaxBatchBytes {
+			b.carry <- msg
+
+			if b.abandoned.Load() {
+				b.failRemaining(ErrDrainTimeout)
+			}
+
+			break
+		}
+
+		msgsWithChs = append(msgsWithChs, msg)
+		batchBytes += msg.size
+	}
+
+	if len(msgsWithChs) == 0 {
+		return
+	}
+
+	b.recordBatch(batchBytes)
+
+	b.opts.Observer.OnFlushStart(FlushStartEvent{
+		Trigger:    trigger,
+		BatchSize:  len(msgsWithChs),
+		QueueDepth: len(b.bufferCh),
+		InFlight:   len(b.semaphore),
+	})
+
+	startedWrite := time.Now()
+
+	err := b.writeAndDeliver(msgsWithChs)
+	writeDuration := time.Since(startedWrite)
+
+	b.opts.Observer.OnFlushEnd(FlushEndEvent{
+		Trigger:   trigger,
+		BatchSize: len(msgsWithChs),
+		Duration:  writeDuration,
+		Err:       err,
+	})
+
+	if b.adaptive != nil {
+		b.adaptive.observe(len(msgsWithChs), maxBatch, writeDuration)
+	}
+}
+
+func (b *Buffer[I, O]) recordBatch(size int) {
+	b.batches.Add(1)
+	b.batchBytes.Add(int64(size))
+
+	for {
+		prev := b.maxBatchBytes.Load()
+
+		if int64(size) <= prev || b.maxBatchBytes.CompareAndSwap(prev, int64(size)) {
+			return
+		}
+	}
+}
+
+// writeAndDeliver writes the tasks and sends each caller its result or error. Failed
+// writes are first retried according to the retry policy. When BisectFailures is set,
+// a write which still fails is retried as two halves until the failing tasks are
+// isolated. It returns the error from writing the whole batch, if any.
+func (b *Buffer[I, O]) writeAndDeliver(msgsWithChs []*TaskWithErrCh[I, O]) error {
+	tasks := make([]I, 0, len(msgsWithChs))
+
+	for _, msg := range msgsWithChs {
+		tasks = append(tasks, msg.task)
+	}
+
+	var tasksOut []*O
+
+	startedWrite := time.Now()
+
+	retries, retryTime, err := b.opts.Retry.Do(b.ctx, func() error {
+		var err error
+		tasksOut, err = b.write(tasks)
+		return err
+	})
+
+	writeDuration := time.Since(startedWrite)
+
+	for _, msg := range msgsWithChs {
+		msg.writeDuration = writeDuration
+	}
+
+	b.retries.Add(int64(retries))
+	b.retryTime.Add(int64(retryTime))
+
+	if err != nil {
+		if b.opts.BisectFailures && len(msgsWithChs) > 1 && b.isTaskError(err) {
+			b.bisections.Add(1)
+
+			mid := len(msgsWithChs) / 2
+
+			b.writeAndDeliver(msgsWithChs[:mid])
+			b.writeAndDeliver(msgsWithChs[mid:])
+
+			return err
+		}
+
+		for _, msgWithErrCh := range msgsWithChs {
+			b.fail(msgWithErrCh, err)
+		}
+
+		return err
+	}
+
+	if len(tasksOut) != len(tasks) {
+		for _, msgWithErrCh := range msgsWithChs {
+			b.fail(msgWithErrCh, ErrResultCount)
+		}
+
+		return ErrResultCount
+	}
+
+	for i, msgWithErrCh := range msgsWithChs {
+		b.succeed(msgWithErrCh, tasksOut[i])
+	}
+
+	return nil
+}
+
+// isTaskError reports whether a failed write may be caused by some of its tasks, and
+// so is worth bisecting
+func (b *Buffer[I, O]) isTaskError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	retry := b.opts.Retry
+
+	return retry == nil || retry.IsRetryable == nil || !retry.IsRetryable(err)
+}
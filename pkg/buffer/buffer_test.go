@@ -0,0 +1,390 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errBadTask = errors.New("bad task")
+
+// echo returns each task as its own result
+func echo(tasks []int) ([]*int, error) {
+	out := make([]*int, 0, len(tasks))
+
+	for i := range tasks {
+		out = append(out, &tasks[i])
+	}
+
+	return out, nil
+}
+
+// gatedWrite blocks every write until release is closed, and signals started when the
+// first write begins
+type gatedWrite struct {
+	started     chan struct{}
+	release     chan struct{}
+	startedOnce sync.Once
+	write       func([]int) ([]*int, error)
+}
+
+func newGatedWrite(write func([]int) ([]*int, error)) *gatedWrite {
+	return &gatedWrite{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+		write:   write,
+	}
+}
+
+func (g *gatedWrite) Write(tasks []int) ([]*int, error) {
+	g.startedOnce.Do(func() { close(g.started) })
+
+	<-g.release
+
+	return g.write(tasks)
+}
+
+// result is the outcome of a single task
+type result struct {
+	out *int
+	err error
+}
+
+// writeAll writes every task with a callback and returns a channel per task which
+// receives its result
+func writeAll(t *testing.T, b Batcher[int, int], tasks []int) []chan result {
+	t.Helper()
+
+	results := make([]chan result, len(tasks))
+
+	for i, task := range tasks {
+		ch := make(chan result, 1)
+		results[i] = ch
+
+		err := b.WriteWithCallback(context.Background(), task, func(out *int, err error) {
+			ch <- result{out, err}
+		})
+
+		if err != nil {
+			t.Fatalf("could not write task %d: %v", task, err)
+		}
+	}
+
+	return results
+}
+
+func waitResult(t *testing.T, ch chan result) result {
+	t.Helper()
+
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a result")
+		return result{}
+	}
+}
+
+func TestCloseDrainsBuffer(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options[int]
+	}{
+		{
+			name: "single flusher",
+			opts: Options[int]{MaxBatchSize: 10, MaxConcurrentFlushes: 1, ChannelCapacity: 1000},
+		},
+		{
+			name: "concurrent flushers",
+			opts: Options[int]{MaxBatchSize: 10, MaxConcurrentFlushes: 4, ChannelCapacity: 1000},
+		},
+		{
+			name: "batches capped by bytes",
+			opts: Options[int]{
+				MaxBatchSize:         10,
+				MaxConcurrentFlushes: 4,
+				ChannelCapacity:      1000,
+				SizeOf:               func(int) int { return 10 },
+				MaxBatchBytes:        25,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Linger = time.Millisecond
+
+			b := New(context.Background(), tt.opts, echo)
+
+			tasks := make([]int, 500)
+
+			for i := range tasks {
+				tasks[i] = i
+			}
+
+			results := writeAll(t, b, tasks)
+
+			if err := b.Close(context.Background()); err != nil {
+				t.Fatalf("Close returned an error: %v", err)
+			}
+
+			for i, ch := range results {
+				r := waitResult(t, ch)
+
+				if r.err != nil || *r.out != tasks[i] {
+					t.Fatalf("task %d: got (%v, %v), want (%d, nil)", i, r.out, r.err, tasks[i])
+				}
+			}
+
+			if _, err := b.WriteNoWait(1); !errors.Is(err, ErrClosed) {
+				t.Fatalf("write after Close: got %v, want ErrClosed", err)
+			}
+		})
+	}
+}
+
+func TestCloseDrainTimeout(t *testing.T) {
+	gate := newGatedWrite(echo)
+
+	b := New(context.Background(), Options[int]{
+		MaxBatchSize:         1,
+		MaxConcurrentFlushes: 1,
+		ChannelCapacity:      10,
+		Linger:               time.Millisecond,
+	}, gate.Write)
+
+	results := writeAll(t, b, []int{0})
+
+	// the first task holds the only flush slot until the gate is released
+	<-gate.started
+
+	results = append(results, writeAll(t, b, []int{1, 2, 3})...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := b.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Close: got %v, want context.DeadlineExceeded", err)
+	}
+
+	for i, ch := range results[1:] {
+		if r := waitResult(t, ch); !errors.Is(r.err, ErrDrainTimeout) {
+			t.Fatalf("queued task %d: got %v, want ErrDrainTimeout", i+1, r.err)
+		}
+	}
+
+	close(gate.release)
+
+	if r := waitResult(t, results[0]); r.err != nil {
+		t.Fatalf("in-flight task: got %v, want success", r.err)
+	}
+}
+
+func TestBisectFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		bisect  bool
+		bad     map[int]bool
+		wantErr map[int]bool
+	}{
+		{
+			name:    "no bad tasks",
+			bisect:  true,
+			bad:     map[int]bool{},
+			wantErr: map[int]bool{},
+		},
+		{
+			name:    "one bad task is isolated",
+			bisect:  true,
+			bad:     map[int]bool{5: true},
+			wantErr: map[int]bool{5: true},
+		},
+		{
+			name:    "several bad tasks are isolated",
+			bisect:  true,
+			bad:     map[int]bool{1: true, 2: true, 8: true},
+			wantErr: map[int]bool{1: true, 2: true, 8: true},
+		},
+		{
+			name:    "without bisection the whole batch fails",
+			bisect:  false,
+			bad:     map[int]bool{5: true},
+			wantErr: map[int]bool{1: true, 2: true, 3: true, 4: true, 5: true, 6: true, 7: true, 8: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gate := newGatedWrite(func(tasks []int) ([]*int, error) {
+				for _, task := range tasks {
+					if tt.bad[task] {
+						return nil, errBadTask
+					}
+				}
+
+				return echo(tasks)
+			})
+
+			b := New(context.Background(), Options[int]{
+				MaxBatchSize:         8,
+				MaxConcurrentFlushes: 1,
+				Linger:               time.Millisecond,
+				BisectFailures:       tt.bisect,
+			}, gate.Write)
+
+			// task 0 is flushed on its own and holds the flush slot, so that tasks 1 to 8
+			// are written as a single batch once the gate is released
+			results := writeAll(t, b, []int{0})
+
+			<-gate.started
+
+			results = append(results, writeAll(t, b, []int{1, 2, 3, 4, 5, 6, 7, 8})...)
+
+			close(gate.release)
+
+			for task, ch := range results {
+				r := waitResult(t, ch)
+
+				if tt.wantErr[task] != errors.Is(r.err, errBadTask) {
+					t.Fatalf("task %d: got error %v, want error %v", task, r.err, tt.wantErr[task])
+				}
+			}
+
+			if err := b.Close(context.Background()); err != nil {
+				t.Fatalf("Close returned an error: %v", err)
+			}
+
+			if gotBisections := b.Stats().Bisections > 0; gotBisections != (tt.bisect && len(tt.bad) > 0) {
+				t.Fatalf("got %d bisections", b.Stats().Bisections)
+			}
+		})
+	}
+}
+
+func TestBisectSkipsBatchErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		wantBisections bool
+	}{
+		{
+			name:           "permanent error is bisected",
+			err:            errPermanent,
+			wantBisections: true,
+		},
+		{
+			name: "transient error is not bisected",
+			err:  errTransient,
+		},
+		{
+			name: "canceled context is not bisected",
+			err:  context.Canceled,
+		},
+		{
+			name: "deadline exceeded is not bisected",
+			err:  fmt.Errorf("write: %w", context.DeadlineExceeded),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gate := newGatedWrite(func(tasks []int) ([]*int, error) {
+				if len(tasks) == 1 && tasks[0] == 0 {
+					return echo(tasks)
+				}
+
+				return nil, tt.err
+			})
+
+			b := New(context.Background(), Options[int]{
+				MaxBatchSize:         8,
+				MaxConcurrentFlushes: 1,
+				Linger:               time.Millisecond,
+				BisectFailures:       true,
+				Retry: &RetryPolicy{
+					MaxAttempts:    2,
+					InitialBackoff: time.Millisecond,
+					IsRetryable:    isTransient,
+				},
+			}, gate.Write)
+
+			results := writeAll(t, b, []int{0})
+
+			<-gate.started
+
+			results = append(results, writeAll(t, b, []int{1, 2, 3, 4, 5, 6, 7, 8})...)
+
+			close(gate.release)
+
+			for task, ch := range results[1:] {
+				if r := waitResult(t, ch); !errors.Is(r.err, tt.err) {
+					t.Fatalf("task %d: got %v, want %v", task+1, r.err, tt.err)
+				}
+			}
+
+			if err := b.Close(context.Background()); err != nil {
+				t.Fatalf("Close returned an error: %v", err)
+			}
+
+			if gotBisections := b.Stats().Bisections > 0; gotBisections != tt.wantBisections {
+				t.Fatalf("got %d bisections, want bisections %v", b.Stats().Bisections, tt.wantBisections)
+			}
+		})
+	}
+}
+
+func TestResultCountMismatch(t *testing.T) {
+	b := New(context.Background(), Options[int]{}, func(tasks []int) ([]*int, error) {
+		return nil, nil
+	})
+
+	if _, err := b.Write(1); !errors.Is(err, ErrResultCount) {
+		t.Fatalf("got %v, want ErrResultCount", err)
+	}
+
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+}
+
+// TestCloseDeliversCarriedTasks closes buffers while flushes are carrying tasks over
+// to the next batch. A task which is carried over after Close has checked the buffer
+// must still be flushed before Close returns.
+func TestCloseDeliversCarriedTasks(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		b := New(context.Background(), Options[int]{
+			MaxBatchSize:         10,
+			MaxConcurrentFlushes: 8,
+			Linger:               time.Millisecond,
+			SizeOf:               func(int) int { return 10 },
+			// every batch holds a single task and carries the next one over
+			MaxBatchBytes: 15,
+		}, echo)
+
+		tasks := make([]int, 50)
+
+		for j := range tasks {
+			tasks[j] = j
+		}
+
+		results := writeAll(t, b, tasks)
+
+		if err := b.Close(context.Background()); err != nil {
+			t.Fatalf("Close returned an error: %v", err)
+		}
+
+		for j, ch := range results {
+			select {
+			case r := <-ch:
+				if r.err != nil {
+					t.Fatalf("task %d: got %v, want success", j, r.err)
+				}
+			default:
+				t.Fatalf("iteration %d: task %d had no result when Close returned", i, j)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package buffer
+
+import "time"
+
+// FlushTrigger is the reason a flush was started
+type FlushTrigger int
+
+const (
+	// FlushTriggerTicker is a flush started by the Linger ticker
+	FlushTriggerTicker FlushTrigger = iota
+
+	// FlushTriggerNotifier is a flush started because a task was written
+	FlushTriggerNotifier
+
+	// FlushTriggerShutdown is the final flush when the buffer's context is done
+	FlushTriggerShutdown
+
+	// FlushTriggerClose is a flush started by Close to drain the buffer
+	FlushTriggerClose
+)
+
+func (t FlushTrigger) String() string {
+	switch t {
+	case FlushTriggerTicker:
+		return "ticker"
+	case FlushTriggerNotifier:
+		return "notifier"
+	case FlushTriggerShutdown:
+		return "shutdown"
+	case FlushTriggerClose:
+		return "close"
+	default:
+		return "unknown"
+	}
+}
+
+// FlushStartEvent is passed to Observer.OnFlushStart
+type FlushStartEvent struct {
+	Trigger FlushTrigger
+
+	// BatchSize is the number of tasks in the batch
+	BatchSize int
+
+	// QueueDepth is the number of tasks left in the buffer after the batch was read
+	QueueDepth int
+
+	// InFlight is the number of flushes holding a concurrency slot, including this one
+	InFlight int
+}
+
+// FlushEndEvent is passed to Observer.OnFlushEnd
+type FlushEndEvent struct {
+	Trigger   FlushTrigger
+	BatchSize int
+
+	// Duration is the time spent writing the batch, including retries and bisection
+	Duration time.Duration
+
+	// Err is the error returned by the first attempt to write the whole batch, or nil
+	Err error
+}
+
+// ResultEvent is passed to Observer.OnResult when a task's result or error is
+// delivered to its caller
+type ResultEvent struct {
+	// QueueWait is the time between the task being enqueued and read into a batch.
+	// It is zero for tasks which were never flushed.
+	QueueWait time.Duration
+
+	// Write is the duration of the write which produced the result, including retries.
+	// When a batch is bisected, it is the duration of the smallest write containing the
+	// task. It is zero for tasks which were never flushed.
+	Write time.Duration
+
+	// Total is the time between the task being enqueued and its result being delivered
+	Total time.Duration
+
+	Err error
+}
+
+// Observer receives events from a Buffer. Methods are called synchronously from the
+// writer and flusher goroutines, so they must be safe for concurrent use and return
+// quickly.
+type Observer interface {
+	// OnEnqueue is called after a task is added, with the number of queued tasks
+	OnEnqueue(queueDepth int)
+
+	// OnSaturated is called when a flush could not start because every concurrency
+	// slot was taken
+	OnSaturated(trigger FlushTrigger)
+
+	OnFlushStart(e FlushStartEvent)
+	OnFlushEnd(e FlushEndEvent)
+	OnResult(e ResultEvent)
+}
+
+// NoopObserver ignores all events. It can be embedded to implement a subset of
+// Observer.
+type NoopObserver struct{}
+
+func (NoopObserver) OnEnqueue(int)                {}
+func (NoopObserver) OnSaturated(FlushTrigger)     {}
+func (NoopObserver) OnFlushStart(FlushStartEvent) {}
+func (NoopObserver) OnFlushEnd(FlushEndEvent)     {}
+func (NoopObserver) OnResult(ResultEvent)         {}
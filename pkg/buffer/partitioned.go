@@ -0,0 +1,149 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// Batcher is implemented by Buffer and PartitionedBuffer
+type Batcher[I, O any] interface {
+	WriteNoWait(task I) (*TaskWithErrCh[I, O], error)
+	WriteNoWaitContext(ctx context.Context, task I) (*TaskWithErrCh[I, O], error)
+	WriteWithCallback(ctx context.Context, task I, done func(*O, error)) error
+	Write(task I) (*O, error)
+	Close(ctx context.Context) error
+	Limits() (int, time.Duration)
+	QueueDepth() int
+	Stats() Stats
+}
+
+var (
+	_ Batcher[any, any] = (*Buffer[any, any])(nil)
+	_ Batcher[any, any] = (*PartitionedBuffer[any, any])(nil)
+)
+
+// PartitionedBuffer routes each task to one of several lanes by hashing its key. Each
+// lane is a Buffer which flushes one batch at a time, so tasks with the same key are
+// written in the order they were enqueued, while different lanes flush in parallel.
+type PartitionedBuffer[I, O any] struct {
+	lanes []*Buffer[I, O]
+	key   func(I) string
+}
+
+// NewPartitioned creates a buffer with the given number of lanes. Options apply to
+// each lane, except that MaxConcurrentFlushes is always 1 and ChannelCapacity and
+// MaxBufferedBytes are split evenly between the lanes.
+func NewPartitioned[I, O any](
+	ctx context.Context,
+	opts Options[I],
+	lanes int,
+	key func(I) string,
+	write func(task []I) ([]*O, error),
+) *PartitionedBuffer[I, O] {
+	opts = opts.withDefaults()
+
+	lanes = max(lanes, 1)
+
+	laneOpts := opts
+	laneOpts.MaxConcurrentFlushes = 1
+	laneOpts.ChannelCapacity = max(opts.ChannelCapacity/lanes, opts.MaxBatchSize)
+
+	if opts.MaxBufferedBytes > 0 {
+		laneOpts.MaxBufferedBytes = max(opts.MaxBufferedBytes/lanes, 1)
+	}
+
+	p := &PartitionedBuffer[I, O]{
+		lanes: make([]*Buffer[I, O], 0, lanes),
+		key:   key,
+	}
+
+	for i := 0; i < lanes; i++ {
+		p.lanes = append(p.lanes, New(ctx, laneOpts, write))
+	}
+
+	return p
+}
+
+func (p *PartitionedBuffer[I, O]) lane(task I) *Buffer[I, O] {
+	h := fnv.New32a()
+	h.Write([]byte(p.key(task)))
+
+	return p.lanes[h.Sum32()%uint32(len(p.lanes))]
+}
+
+func (p *PartitionedBuffer[I, O]) WriteNoWait(task I) (*TaskWithErrCh[I, O], error) {
+	return p.lane(task).WriteNoWait(task)
+}
+
+func (p *PartitionedBuffer[I, O]) WriteNoWaitContext(ctx context.Context, task I) (*TaskWithErrCh[I, O], error) {
+	return p.lane(task).WriteNoWaitContext(ctx, task)
+}
+
+func (p *PartitionedBuffer[I, O]) WriteWithCallback(ctx context.Context, task I, done func(*O, error)) error {
+	return p.lane(task).WriteWithCallback(ctx, task, done)
+}
+
+func (p *PartitionedBuffer[I, O]) Write(task I) (*O, error) {
+	return p.lane(task).Write(task)
+}
+
+// Close closes every lane concurrently, see Buffer.Close.
+func (p *PartitionedBuffer[I, O]) Close(ctx context.Context) error {
+	errs := make([]error, len(p.lanes))
+
+	var wg sync.WaitGroup
+
+	for i, lane := range p.lanes {
+		wg.Add(1)
+
+		go func(i int, lane *Buffer[I, O]) {
+			defer wg.Done()
+
+			errs[i] = lane.Close(ctx)
+		}(i, lane)
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Limits returns the limits of the first lane. With adaptive batching, each lane
+// adapts independently.
+func (p *PartitionedBuffer[I, O]) Limits() (int, time.Duration) {
+	return p.lanes[0].Limits()
+}
+
+// QueueDepth returns the number of tasks waiting to be flushed across all lanes.
+func (p *PartitionedBuffer[I, O]) QueueDepth() int {
+	depth := 0
+
+	for _, lane := range p.lanes {
+		depth += lane.QueueDepth()
+	}
+
+	return depth
+}
+
+// Stats returns the sum of the counters of every lane.
+func (p *PartitionedBuffer[I, O]) Stats() Stats {
+	var total Stats
+
+	for _, lane := range p.lanes {
+		s := lane.Stats()
+
+		total.Bisections += s.Bisections
+		total.Retries += s.Retries
+		total.RetryTime += s.RetryTime
+		total.Batches += s.Batches
+		total.BatchBytes += s.BatchBytes
+		total.MaxBatchBytes = max(total.MaxBatchBytes, s.MaxBatchBytes)
+		total.Rejected += s.Rejected
+		total.Shed += s.Shed
+	}
+
+	return total
+}
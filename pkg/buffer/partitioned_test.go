@@ -0,0 +1,94 @@
+package buffer
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPartitionedBufferPreservesKeyOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		lanes int
+		keys  int
+	}{
+		{name: "one lane", lanes: 1, keys: 8},
+		{name: "fewer keys than lanes", lanes: 8, keys: 3},
+		{name: "more keys than lanes", lanes: 4, keys: 32},
+	}
+
+	const tasksPerKey = 200
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a task is key*tasksPerKey + its sequence number within the key
+			key := func(task int) string {
+				return strconv.Itoa(task / tasksPerKey)
+			}
+
+			var mu sync.Mutex
+
+			written := map[string][]int{}
+
+			write := func(tasks []int) ([]*int, error) {
+				// vary the write time so that lanes finish out of order
+				time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				for _, task := range tasks {
+					written[key(task)] = append(written[key(task)], task)
+				}
+
+				return echo(tasks)
+			}
+
+			b := NewPartitioned(context.Background(), Options[int]{
+				MaxBatchSize:    16,
+				ChannelCapacity: tt.keys * tasksPerKey,
+				Linger:          time.Millisecond,
+			}, tt.lanes, key, write)
+
+			// interleave the keys
+			tasks := make([]int, 0, tt.keys*tasksPerKey)
+
+			for seq := 0; seq < tasksPerKey; seq++ {
+				for k := 0; k < tt.keys; k++ {
+					tasks = append(tasks, k*tasksPerKey+seq)
+				}
+			}
+
+			results := writeAll(t, b, tasks)
+
+			if err := b.Close(context.Background()); err != nil {
+				t.Fatalf("Close returned an error: %v", err)
+			}
+
+			for _, ch := range results {
+				if r := waitResult(t, ch); r.err != nil {
+					t.Fatalf("got %v, want success", r.err)
+				}
+			}
+
+			if len(written) != tt.keys {
+				t.Fatalf("got %d keys written, want %d", len(written), tt.keys)
+			}
+
+			for k, ordered := range written {
+				for i := 1; i < len(ordered); i++ {
+					if ordered[i] < ordered[i-1] {
+						t.Fatalf("key %s: task %d was written after task %d", k, ordered[i], ordered[i-1])
+					}
+				}
+
+				if len(ordered) != tasksPerKey {
+					t.Fatalf("key %s: got %d tasks, want %d", k, len(ordered), tasksPerKey)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package buffer
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy retries failed writes with jittered exponential backoff.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first. Values below 2
+	// disable retries.
+	MaxAttempts int
+
+	// InitialBackoff is the upper bound of the wait before the first retry. It doubles
+	// after each retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Budget caps the total time spent retrying a single write. Zero means no limit.
+	Budget time.Duration
+
+	// IsRetryable reports whether an error is transient. If nil, no errors are retried.
+	IsRetryable func(error) bool
+}
+
+// Do calls fn until it succeeds, returns a permanent error or the policy is exhausted.
+// It returns the number of retries made and the time spent between the first failure
+// and the final attempt returning.
+func (p *RetryPolicy) Do(ctx context.Context, fn func() error) (int, time.Duration, error) {
+	err := fn()
+
+	if err == nil || p == nil || p.MaxAttempts < 2 || p.IsRetryable == nil {
+		return 0, 0, err
+	}
+
+	firstFailure := time.Now()
+	backoff := p.InitialBackoff
+	retries := 0
+
+	for attempt := 1; attempt < p.MaxAttempts && p.IsRetryable(err); attempt++ {
+		wait := jitter(backoff)
+
+		if p.Budget > 0 && time.Since(firstFailure)+wait > p.Budget {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return retries, time.Since(firstFailure), err
+		case <-time.After(wait):
+		}
+
+		retries++
+
+		if err = fn(); err == nil {
+			break
+		}
+
+		backoff *= 2
+
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+
+	return retries, time.Since(firstFailure), err
+}
+
+// jitter returns a random duration in [d/2, d)
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+
+	half := d / 2
+
+	return half + time.Duration(rand.Int63n(int64(d-half)))
+}
@@ -0,0 +1,151 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	errTransient = errors.New("transient")
+	errPermanent = errors.New("permanent")
+)
+
+func isTransient(err error) bool {
+	return errors.Is(err, errTransient)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      *RetryPolicy
+		errs        []error
+		wantCalls   int
+		wantRetries int
+		wantErr     error
+	}{
+		{
+			name:      "nil policy does not retry",
+			policy:    nil,
+			errs:      []error{errTransient, nil},
+			wantCalls: 1,
+			wantErr:   errTransient,
+		},
+		{
+			name:      "success is not retried",
+			policy:    &RetryPolicy{MaxAttempts: 3, IsRetryable: isTransient},
+			errs:      []error{nil},
+			wantCalls: 1,
+		},
+		{
+			name:      "permanent errors are not retried",
+			policy:    &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, IsRetryable: isTransient},
+			errs:      []error{errPermanent, nil},
+			wantCalls: 1,
+			wantErr:   errPermanent,
+		},
+		{
+			name:      "one attempt disables retries",
+			policy:    &RetryPolicy{MaxAttempts: 1, InitialBackoff: time.Millisecond, IsRetryable: isTransient},
+			errs:      []error{errTransient, nil},
+			wantCalls: 1,
+			wantErr:   errTransient,
+		},
+		{
+			name:        "transient errors are retried until success",
+			policy:      &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond, IsRetryable: isTransient},
+			errs:        []error{errTransient, errTransient, nil},
+			wantCalls:   3,
+			wantRetries: 2,
+		},
+		{
+			name:        "retries stop after max attempts",
+			policy:      &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, IsRetryable: isTransient},
+			errs:        []error{errTransient, errTransient, errTransient, nil},
+			wantCalls:   3,
+			wantRetries: 2,
+			wantErr:     errTransient,
+		},
+		{
+			name:        "retries stop at a permanent error",
+			policy:      &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond, IsRetryable: isTransient},
+			errs:        []error{errTransient, errPermanent, nil},
+			wantCalls:   2,
+			wantRetries: 1,
+			wantErr:     errPermanent,
+		},
+		{
+			name: "retries stop when the next wait exceeds the budget",
+			policy: &RetryPolicy{
+				MaxAttempts:    5,
+				InitialBackoff: 200 * time.Millisecond,
+				Budget:         50 * time.Millisecond,
+				IsRetryable:    isTransient,
+			},
+			errs:      []error{errTransient, nil},
+			wantCalls: 1,
+			wantErr:   errTransient,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+
+			retries, _, err := tt.policy.Do(context.Background(), func() error {
+				err := tt.errs[calls]
+				calls++
+				return err
+			})
+
+			if calls != tt.wantCalls || retries != tt.wantRetries || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %d calls, %d retries and error %v, want %d calls, %d retries and error %v",
+					calls, retries, err, tt.wantCalls, tt.wantRetries, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	policy := &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour, IsRetryable: isTransient}
+
+	retries, _, err := policy.Do(ctx, func() error {
+		return errTransient
+	})
+
+	if retries != 0 || !errors.Is(err, errTransient) {
+		t.Fatalf("got %d retries and error %v, want 0 retries and the last write error", retries, err)
+	}
+}
+
+func TestBufferRetriesWrites(t *testing.T) {
+	calls := 0
+
+	b := New(context.Background(), Options[int]{
+		Retry: &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, IsRetryable: isTransient},
+	}, func(tasks []int) ([]*int, error) {
+		calls++
+
+		if calls == 1 {
+			return nil, errTransient
+		}
+
+		return echo(tasks)
+	})
+
+	if _, err := b.Write(1); err != nil {
+		t.Fatalf("got %v, want the retried write to succeed", err)
+	}
+
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatalf("Close returned an error: %v", err)
+	}
+
+	if stats := b.Stats(); stats.Retries != 1 {
+		t.Fatalf("got %d retries, want 1", stats.Retries)
+	}
+}
@@ -1,83 +1,417 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/abelanger5/postgres-fast-inserts/internal/histogram"
+	"github.com/abelanger5/postgres-fast-inserts/pkg/buffer"
 )
 
 // Reporter tracks metrics for task execution
 type Reporter struct {
-	taskCount    int
-	numBatches   int
-	totalLatency time.Duration
-	mu           sync.Mutex
+	taskCount   int
+	numBatches  atomic.Int64
+	errorCount  int
+	errClasses  map[string]int
+	sqlStates   map[string]int
+	latency     *histogram.Histogram
+	queueWait   *histogram.Histogram
+	dbLatency   *histogram.Histogram
+	stagesMu    sync.Mutex // guards queueWait and dbLatency, which the buffer observer records without mu
+	batchLimits []BatchLimitsSample
+	interval    *intervalMetrics
+	intervals   []IntervalReport
+	bufferStats buffer.Stats
+	buffer      *bufferMetrics
+	retries     int64
+	retryTime   time.Duration
+	targetRate  float64
+	copy        copyStats
+	pipeline    pipelineStats
+	arrival     ArrivalProcess
+	serverStart *serverSnapshot
+	start       time.Time
+	mu          sync.Mutex
+}
+
+// BatchLimitsSample records the batch size and flush interval in use at a point in the run
+type BatchLimitsSample struct {
+	Elapsed       string `json:"elapsed"`
+	BatchSize     int    `json:"batchSize"`
+	FlushInterval string `json:"flushInterval"`
+}
+
+// LatencyReport summarizes a latency histogram for JSON output
+type LatencyReport struct {
+	Min       string             `json:"min"`
+	P50       string             `json:"p50"`
+	P90       string             `json:"p90"`
+	P99       string             `json:"p99"`
+	P999      string             `json:"p999"`
+	Max       string             `json:"max"`
+	Histogram []histogram.Bucket `json:"histogram,omitempty"`
+}
+
+func newLatencyReport(h *histogram.Histogram, withBuckets bool) LatencyReport {
+	report := LatencyReport{
+		Min:  h.Min().String(),
+		P50:  h.Percentile(50).String(),
+		P90:  h.Percentile(90).String(),
+		P99:  h.Percentile(99).String(),
+		P999: h.Percentile(99.9).String(),
+		Max:  h.Max().String(),
+	}
+
+	if withBuckets {
+		report.Histogram = h.Buckets()
+	}
+
+	return report
+}
+
+// ErrorReport breaks down failed tasks for JSON output
+type ErrorReport struct {
+	ByClass    map[string]int `json:"byClass"`
+	BySQLState map[string]int `json:"bySQLState"`
 }
 
 // ReportData represents the data for JSON output
 type ReportData struct {
-	TaskCount    int     `json:"taskCount"`
-	TotalTime    string  `json:"totalTime"`
-	AvgLatency   string  `json:"avgLatency"`
-	Throughput   float64 `json:"throughput"`
-	NumBatches   int     `json:"numBatches"`
-	AvgBatchSize int     `json:"avgBatchSize"`
+	TaskCount    int                 `json:"taskCount"`
+	FailedCount  int                 `json:"failedCount"`
+	Errors       *ErrorReport        `json:"errors,omitempty"`
+	TotalTime    string              `json:"totalTime"`
+	AvgLatency   string              `json:"avgLatency"`
+	Latency      LatencyReport       `json:"latency"`
+	QueueWait    LatencyReport       `json:"queueWait"`
+	DBLatency    LatencyReport       `json:"dbLatency"`
+	Throughput   float64             `json:"throughput"`
+	NumBatches   int                 `json:"numBatches"`
+	AvgBatchSize int                 `json:"avgBatchSize"`
+	StdDev       float64             `json:"throughputStdDev"`
+	CV           float64             `json:"throughputCV"`
+	Intervals    []IntervalReport    `json:"intervals,omitempty"`
+	PerSecond    []int               `json:"throughputPerSecond,omitempty"`
+	BatchSize    int                 `json:"batchSize,omitempty"`
+	Interval     string              `json:"flushInterval,omitempty"`
+	BatchLimits  []BatchLimitsSample `json:"batchLimits,omitempty"`
+	Bisections   int64               `json:"bisections,omitempty"`
+	Partitions   int                 `json:"partitions,omitempty"`
+	Keys         int                 `json:"keys,omitempty"`
+	AvgBatchKB   float64             `json:"avgBatchKB,omitempty"`
+	MaxBatchKB   float64             `json:"maxBatchKB,omitempty"`
+	Rejected     int64               `json:"rejected"`
+	Shed         int64               `json:"shed"`
+	Retries      int64               `json:"retries"`
+	RetryTime    string              `json:"retryTime"`
+	ExecMode     string              `json:"execMode"`
+	CopyBytes    int64               `json:"copyBytes,omitempty"`
+	CopyEncode   string              `json:"copyEncodeTime,omitempty"`
+	Pipeline     int                 `json:"pipelineDepth,omitempty"`
+	AvgInFlight  float64             `json:"avgPipelineInFlight,omitempty"`
+	MaxInFlight  int                 `json:"maxPipelineInFlight,omitempty"`
+	TargetRate   float64             `json:"targetRate,omitempty"`
+	Arrival      string              `json:"arrival,omitempty"`
+	Shortfall    float64             `json:"rateShortfall,omitempty"`
+	Buffer       *BufferReport       `json:"buffer,omitempty"`
+	Server       *ServerReport       `json:"server,omitempty"`
 }
 
 // NewReporter creates a new Reporter instance
 func NewReporter() *Reporter {
+	var serverStart *serverSnapshot
+
+	if serverStats {
+		serverStart = takeServerSnapshot(context.Background())
+	}
+
+	start := time.Now()
+
 	return &Reporter{
-		taskCount:    0,
-		totalLatency: 0,
-		mu:           sync.Mutex{},
+		serverStart: serverStart,
+		taskCount:   0,
+		errClasses:  make(map[string]int),
+		sqlStates:   make(map[string]int),
+		latency:     histogram.New(),
+		queueWait:   histogram.New(),
+		dbLatency:   histogram.New(),
+		interval:    newIntervalMetrics(start),
+		start:       start,
+		mu:          sync.Mutex{},
 	}
 }
 
-// RecordTask records a task execution with its latency
+// RecordTask records a task which was written successfully and directly, without
+// waiting in a queue, so its end-to-end latency is its database latency
 func (r *Reporter) RecordTask(latency time.Duration) {
+	r.RecordTaskStages(0, latency, latency, nil)
+}
+
+// RecordTaskStages records a task execution with the time it spent waiting to be
+// written, the duration of the database write and its end-to-end latency. Latencies
+// are only recorded for successful tasks.
+func (r *Reporter) RecordTaskStages(queueWait, dbLatency, endToEnd time.Duration, err error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+
+	if err != nil {
+		r.recordError(err)
+		return
+	}
+
+	r.recordStages(queueWait, dbLatency)
+	r.recordEndToEnd(endToEnd)
+}
+
+func (r *Reporter) recordStages(queueWait, dbLatency time.Duration) {
+	r.stagesMu.Lock()
+	defer r.stagesMu.Unlock()
+
+	r.queueWait.Record(queueWait)
+	r.dbLatency.Record(dbLatency)
+}
+
+func (r *Reporter) recordEndToEnd(latency time.Duration) {
 	r.taskCount++
-	r.totalLatency += latency
+	r.latency.Record(latency)
+	r.interval.recordTask(r.start, latency)
+}
+
+func (r *Reporter) recordError(err error) {
+	sqlState, class := classifyError(err)
+
+	r.errorCount++
+	r.errClasses[class]++
+	r.interval.errors++
+
+	if sqlState != "" {
+		r.sqlStates[sqlState]++
+	}
+}
+
+// RecordBufferedTask records the result of a task written through a buffer. Its queue
+// wait and database latency are recorded by the buffer's observer.
+func (r *Reporter) RecordBufferedTask(endToEnd time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		r.recordError(err)
+		return
+	}
+
+	r.recordEndToEnd(endToEnd)
+}
+
+// SetTargetRate records the open-loop arrival rate, in tasks per second, so that the
+// report can show how far the achieved throughput fell short of it
+func (r *Reporter) SetTargetRate(rate float64, arrival ArrivalProcess) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.targetRate = rate
+	r.arrival = arrival
+}
+
+// RecordCopy adds the client-side cost of a streamed COPY
+func (r *Reporter) RecordCopy(stats copyStats) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.copy.rows += stats.rows
+	r.copy.bytes += stats.bytes
+	r.copy.encode += stats.encode
+}
+
+// SetPipelineDepth records the configured number of batches in flight per connection
+func (r *Reporter) SetPipelineDepth(depth int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pipeline.depth = depth
+}
+
+// RecordPipelineDepth records the number of batches in flight on a connection after
+// a batch has been sent
+func (r *Reporter) RecordPipelineDepth(inFlight int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pipeline.samples++
+	r.pipeline.sum += int64(inFlight)
+	r.pipeline.max = max(r.pipeline.max, inFlight)
 }
 
 // RecordBatch records a batch execution
 func (r *Reporter) RecordBatch() {
+	r.numBatches.Add(1)
+}
+
+// RecordBatchLimits records the batch size and flush interval currently in use
+func (r *Reporter) RecordBatchLimits(batchSize int, flushInterval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.batchLimits = append(r.batchLimits, BatchLimitsSample{
+		Elapsed:       time.Since(r.start).Round(time.Millisecond).String(),
+		BatchSize:     batchSize,
+		FlushInterval: flushInterval.String(),
+	})
+}
+
+// RecordBufferStats records the counters collected by a buffer during the run
+func (r *Reporter) RecordBufferStats(stats buffer.Stats) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	r.numBatches++
+	r.bufferStats = stats
+	r.retries += stats.Retries
+	r.retryTime += stats.RetryTime
+}
+
+// RecordRetries records retries made outside of a buffer
+func (r *Reporter) RecordRetries(retries int, retryTime time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retries += int64(retries)
+	r.retryTime += retryTime
+}
+
+// TrackBatchLimits samples limits every interval until the context is done
+func (r *Reporter) TrackBatchLimits(ctx context.Context, interval time.Duration, limits func() (int, time.Duration)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.RecordBatchLimits(limits())
+			}
+		}
+	}()
 }
 
 // Print outputs a report of execution metrics to the console
 func (r *Reporter) Print(elapsed time.Duration) {
+	var serverEnd *serverSnapshot
+
+	if r.serverStart != nil {
+		flushBackendStats(context.Background())
+		serverEnd = takeServerSnapshot(context.Background())
+	}
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	var avgLatency time.Duration
-	if r.taskCount > 0 {
-		avgLatency = r.totalLatency / time.Duration(r.taskCount)
-	}
+	r.stagesMu.Lock()
+	defer r.stagesMu.Unlock()
+
+	numBatches := int(r.numBatches.Load())
+
+	server := newServerReport(r.serverStart, serverEnd, r.taskCount)
+
+	avgLatency := r.latency.Mean()
 
 	throughput := float64(r.taskCount) / elapsed.Seconds()
 
 	// Calculate average batch size safely
 	avgBatchSize := 0
-	if r.numBatches > 0 {
-		avgBatchSize = r.taskCount / r.numBatches
+	if numBatches > 0 {
+		avgBatchSize = r.taskCount / numBatches
 	}
 
-	if jsonOutput {
-		// Output as JSON
-		report := ReportData{
-			TaskCount:    r.taskCount,
-			TotalTime:    elapsed.String(),
-			AvgLatency:   avgLatency.String(),
-			Throughput:   throughput,
-			NumBatches:   r.numBatches,
-			AvgBatchSize: avgBatchSize,
+	var avgBatchKB, maxBatchKB float64
+	if r.bufferStats.Batches > 0 {
+		avgBatchKB = float64(r.bufferStats.BatchBytes) / float64(r.bufferStats.Batches) / 1024
+		maxBatchKB = float64(r.bufferStats.MaxBatchBytes) / 1024
+	}
+
+	stddev, cv := r.throughputStability()
+
+	report := ReportData{
+		TaskCount:    r.taskCount,
+		FailedCount:  r.errorCount,
+		TotalTime:    elapsed.String(),
+		AvgLatency:   avgLatency.String(),
+		Latency:      newLatencyReport(r.latency, true),
+		QueueWait:    newLatencyReport(r.queueWait, true),
+		DBLatency:    newLatencyReport(r.dbLatency, true),
+		Throughput:   throughput,
+		NumBatches:   numBatches,
+		AvgBatchSize: avgBatchSize,
+		StdDev:       stddev,
+		CV:           cv,
+		Intervals:    r.intervals,
+		PerSecond:    r.completedSeconds(),
+		BatchLimits:  r.batchLimits,
+		Bisections:   r.bufferStats.Bisections,
+		Partitions:   partitions,
+		Keys:         partitionKeys,
+		AvgBatchKB:   avgBatchKB,
+		MaxBatchKB:   maxBatchKB,
+		Rejected:     r.bufferStats.Rejected,
+		Shed:         r.bufferStats.Shed,
+		Retries:      r.retries,
+		RetryTime:    r.retryTime.String(),
+		ExecMode:     execMode,
+	}
+
+	if r.copy.bytes > 0 {
+		report.CopyBytes = r.copy.bytes
+		report.CopyEncode = r.copy.encode.String()
+	}
+
+	if r.pipeline.depth > 0 {
+		report.Pipeline = r.pipeline.depth
+		report.AvgInFlight = r.pipeline.avg()
+		report.MaxInFlight = r.pipeline.max
+	}
+
+	if r.targetRate > 0 {
+		report.TargetRate = r.targetRate
+		report.Arrival = r.arrival.String()
+		report.Shortfall = max(0, (r.targetRate-throughput)/r.targetRate)
+	}
+
+	if r.errorCount > 0 {
+		report.Errors = &ErrorReport{
+			ByClass:    r.errClasses,
+			BySQLState: r.sqlStates,
+		}
+	}
+
+	report.Server = server
+
+	if r.buffer != nil {
+		bufferReport := r.buffer.report()
+		report.Buffer = &bufferReport
+		report.BatchSize = batchSize
+		report.Interval = flushInterval.String()
+	}
+
+	if historyFile != "" {
+		if err := appendHistory(historyFile, report); err != nil {
+			log.Printf("could not record run history: %v", err)
 		}
+	}
+
+	if htmlFile != "" {
+		if err := writeHTMLReport(htmlFile, []namedReport{{Name: executedCommandName(), Report: report}}); err != nil {
+			log.Printf("could not write HTML report: %v", err)
+		}
+	}
 
+	if jsonOutput {
+		// Output as JSON
 		jsonBytes, err := json.MarshalIndent(report, "", "  ")
 		if err != nil {
 			fmt.Printf("Error creating JSON: %v\n", err)
@@ -88,11 +422,113 @@ func (r *Reporter) Print(elapsed time.Duration) {
 		// Output as formatted text
 		fmt.Printf("==== Execution Report ====\n")
 		fmt.Printf("Total tasks executed: %d\n", r.taskCount)
+		fmt.Printf("Total tasks failed: %d\n", r.errorCount)
+
+		for _, class := range sortedKeys(r.errClasses) {
+			fmt.Printf("  %s: %d\n", class, r.errClasses[class])
+		}
+
+		for _, sqlState := range sortedKeys(r.sqlStates) {
+			fmt.Printf("  SQLSTATE %s: %d\n", sqlState, r.sqlStates[sqlState])
+		}
 		fmt.Printf("Total time: %s\n", elapsed)
-		fmt.Printf("Average DB write latency: %s\n", avgLatency)
+		fmt.Printf("Average end-to-end latency: %s\n", avgLatency)
+		printLatency("End-to-end latency", r.latency)
+		printLatency("Queue wait latency", r.queueWait)
+		printLatency("DB write latency", r.dbLatency)
 		fmt.Printf("Throughput: %.2f rows/second\n", throughput)
-		fmt.Printf("Number of batches: %d\n", r.numBatches)
+		if r.targetRate > 0 {
+			fmt.Printf("Target rate: %.2f rows/second (%s arrivals), shortfall %.2f%%\n",
+				report.TargetRate, report.Arrival, report.Shortfall*100)
+		}
+
+		fmt.Printf("Throughput stability: stddev %.2f rows/second, CV %.2f%%\n", stddev, cv*100)
+		fmt.Printf("Number of batches: %d\n", numBatches)
 		fmt.Printf("Average batch size: %d\n", avgBatchSize)
+
+		if partitions > 0 {
+			fmt.Printf("Ordered partitions: %d\n", partitions)
+
+			if partitionKeys > 0 {
+				fmt.Printf("Partition keys: %d\n", partitionKeys)
+			}
+		}
+
+		if r.bufferStats.Batches > 0 {
+			fmt.Printf("Average batch size (KB): %.2f\n", avgBatchKB)
+			fmt.Printf("Max batch size (KB): %.2f\n", maxBatchKB)
+		}
+
+		if r.bufferStats.Rejected > 0 || r.bufferStats.Shed > 0 {
+			fmt.Printf("Rejected tasks: %d\n", r.bufferStats.Rejected)
+			fmt.Printf("Shed tasks: %d\n", r.bufferStats.Shed)
+		}
+
+		if r.copy.rows > 0 {
+			fmt.Printf("COPY wire size: %d bytes (%.2f per row)\n", r.copy.bytes, float64(r.copy.bytes)/float64(r.copy.rows))
+			fmt.Printf("COPY encode time: %s (%s per row)\n", r.copy.encode, r.copy.encode/time.Duration(r.copy.rows))
+		}
+
+		if r.pipeline.depth > 0 {
+			fmt.Printf("Pipeline depth: %d\n", r.pipeline.depth)
+			fmt.Printf("Batches in flight: %.2f average, %d max\n", r.pipeline.avg(), r.pipeline.max)
+		}
+
+		if r.retries > 0 {
+			fmt.Printf("Number of retries: %d\n", r.retries)
+			fmt.Printf("Time spent retrying: %s\n", r.retryTime)
+		}
+
+		if bisectFailures {
+			fmt.Printf("Number of bisections: %d\n", r.bufferStats.Bisections)
+		}
+
+		if r.buffer != nil {
+			b := r.buffer.report()
+
+			fmt.Printf("Buffer result delivery: %s\n", b.ResultDelivery)
+			fmt.Printf("Buffer enqueued tasks: %d\n", b.Enqueued)
+			fmt.Printf("Buffer queue depth: avg %.2f, max %d\n", b.AvgQueueDepth, b.MaxQueueDepth)
+			fmt.Printf("Buffer flushes: ticker %d, notifier %d, shutdown %d, close %d\n",
+				b.FlushesByTrigger["ticker"], b.FlushesByTrigger["notifier"], b.FlushesByTrigger["shutdown"], b.FlushesByTrigger["close"])
+			fmt.Printf("Buffer flushes skipped (all writers busy): ticker %d, notifier %d\n",
+				b.SaturatedByTrigger["ticker"], b.SaturatedByTrigger["notifier"])
+			fmt.Printf("Buffer flush errors: %d\n", b.FlushErrors)
+			fmt.Printf("Buffer flush duration: avg %s\n", b.AvgFlushDuration)
+			fmt.Printf("Buffer max concurrent flushes: %d\n", b.MaxInFlight)
+			fmt.Printf("Buffer queue wait: avg %s, max %s\n", b.AvgQueueWait, b.MaxQueueWait)
+		}
+
+		if server != nil {
+			printServerReport(server)
+		}
+
+		if len(r.batchLimits) > 0 {
+			fmt.Printf("Batch limits over time:\n")
+
+			for _, sample := range r.batchLimits {
+				fmt.Printf("  %s: batch size %d, flush interval %s\n", sample.Elapsed, sample.BatchSize, sample.FlushInterval)
+			}
+		}
+
 		fmt.Printf("========================\n")
 	}
 }
+
+func printLatency(label string, h *histogram.Histogram) {
+	fmt.Printf("%s: avg %s, min %s, p50 %s, p90 %s, p99 %s, p99.9 %s, max %s\n",
+		label, h.Mean(), h.Min(), h.Percentile(50), h.Percentile(90),
+		h.Percentile(99), h.Percentile(99.9), h.Max())
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/abelanger5/postgres-fast-inserts/pkg/buffer"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// SQLSTATE codes which indicate a transient failure, see
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+var retryableSQLStates = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"53300": true, // too_many_connections
+	"55P03": true, // lock_not_available
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+// isRetryableError reports whether err is a transient Postgres or connection error
+// which is worth retrying
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+
+	if errors.As(err, &pgErr) {
+		// class 08 is connection_exception
+		return retryableSQLStates[pgErr.Code] || strings.HasPrefix(pgErr.Code, "08")
+	}
+
+	if pgconn.SafeToRetry(err) {
+		return true
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// retryPolicy builds the retry policy for the continuous commands from the flags
+func retryPolicy() *buffer.RetryPolicy {
+	if retryAttempts < 2 {
+		return nil
+	}
+
+	return &buffer.RetryPolicy{
+		MaxAttempts:    retryAttempts,
+		InitialBackoff: retryBackoff,
+		MaxBackoff:     retryMaxBackoff,
+		Budget:         retryBudget,
+		IsRetryable:    isRetryableError,
+	}
+}
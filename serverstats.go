@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// serverSnapshot holds cumulative server statistics at a point in time. Sections
+// which could not be read, e.g. because the view does not exist on this version of
+// Postgres, are left nil.
+type serverSnapshot struct {
+	walPosition int64
+
+	wal        *walStats
+	database   *databaseStats
+	bgwriter   *bgwriterStats
+	statements *statementStats
+}
+
+type walStats struct {
+	records int64
+	fpi     int64
+	bytes   int64
+}
+
+type databaseStats struct {
+	commits        int64
+	rollbacks      int64
+	blocksRead     int64
+	blocksHit      int64
+	tuplesInserted int64
+}
+
+type bgwriterStats struct {
+	checkpoints    int64
+	buffersWritten int64
+}
+
+type statementStats struct {
+	calls    int64
+	rows     int64
+	execTime float64 // milliseconds
+}
+
+// ServerReport represents the change in server statistics over a run
+type ServerReport struct {
+	WALBytes        int64            `json:"walBytes"`
+	WALBytesPerRow  float64          `json:"walBytesPerRow"`
+	WALRecords      *int64           `json:"walRecords,omitempty"`
+	WALFullPages    *int64           `json:"walFullPageImages,omitempty"`
+	Commits         *int64           `json:"commits,omitempty"`
+	CommitsPerRow   *float64         `json:"commitsPerRow,omitempty"`
+	Rollbacks       *int64           `json:"rollbacks,omitempty"`
+	BlocksRead      *int64           `json:"blocksRead,omitempty"`
+	BlocksHit       *int64           `json:"blocksHit,omitempty"`
+	TuplesInserted  *int64           `json:"tuplesInserted,omitempty"`
+	Checkpoints     *int64           `json:"checkpoints,omitempty"`
+	BuffersWritten  *int64           `json:"buffersWritten,omitempty"`
+	InsertStatement *StatementReport `json:"insertStatements,omitempty"`
+}
+
+// StatementReport represents the pg_stat_statements deltas for the insert queries
+type StatementReport struct {
+	Calls             int64  `json:"calls"`
+	Rows              int64  `json:"rows"`
+	TotalExecTime     string `json:"totalExecTime"`
+	MeanExecTime      string `json:"meanExecTime"`
+	MeanExecTimeByRow string `json:"meanExecTimeByRow"`
+}
+
+// flushBackendStats closes the pool's connections and waits for their backends to
+// exit. A running backend only publishes its statistics periodically, but always does
+// so when it exits, so this makes the end snapshot include every write of the run.
+func flushBackendStats(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	conns := pool.AcquireAllIdle(ctx)
+	pids := make([]int32, 0, len(conns))
+
+	for _, conn := range conns {
+		pids = append(pids, int32(conn.Conn().PgConn().PID()))
+		conn.Release()
+	}
+
+	pool.Reset()
+
+	for {
+		var running int
+
+		err := pool.QueryRow(ctx, "SELECT count(*) FROM pg_stat_activity WHERE pid = ANY($1)", pids).Scan(&running)
+
+		if err != nil {
+			log.Printf("could not wait for backends to exit, server stats may be incomplete: %v", err)
+			return
+		}
+
+		if running == 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("%d backends did not exit, server stats may be incomplete", running)
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// takeServerSnapshot reads the server statistics. Errors are logged and the affected
+// section is skipped.
+func takeServerSnapshot(ctx context.Context) *serverSnapshot {
+	s := &serverSnapshot{}
+
+	err := pool.QueryRow(ctx, "SELECT pg_wal_lsn_diff(pg_current_wal_lsn(), '0/0')::bigint").Scan(&s.walPosition)
+
+	if err != nil {
+		log.Printf("could not read WAL position: %v", err)
+		return nil
+	}
+
+	var wal walStats
+
+	// pg_stat_wal is available from Postgres 14
+	err = pool.QueryRow(ctx, "SELECT wal_records, wal_fpi, wal_bytes::bigint FROM pg_stat_wal").Scan(&wal.records, &wal.fpi, &wal.bytes)
+
+	if err == nil {
+		s.wal = &wal
+	}
+
+	var db databaseStats
+
+	err = pool.QueryRow(ctx, `
+SELECT xact_commit, xact_rollback, blks_read, blks_hit, tup_inserted
+FROM pg_stat_database
+WHERE datname = current_database()`).Scan(&db.commits, &db.rollbacks, &db.blocksRead, &db.blocksHit, &db.tuplesInserted)
+
+	if err != nil {
+		log.Printf("could not read pg_stat_database: %v", err)
+	} else {
+		s.database = &db
+	}
+
+	var bg bgwriterStats
+
+	err = pool.QueryRow(ctx, `
+SELECT checkpoints_timed + checkpoints_req, buffers_checkpoint + buffers_clean + buffers_backend
+FROM pg_stat_bgwriter`).Scan(&bg.checkpoints, &bg.buffersWritten)
+
+	if err != nil {
+		// Postgres 17 moved the checkpoint counters to pg_stat_checkpointer
+		err = pool.QueryRow(ctx, `
+SELECT c.num_timed + c.num_requested, c.buffers_written + b.buffers_clean
+FROM pg_stat_checkpointer c, pg_stat_bgwriter b`).Scan(&bg.checkpoints, &bg.buffersWritten)
+	}
+
+	if err == nil {
+		s.bgwriter = &bg
+	}
+
+	var stmts statementStats
+
+	// requires the pg_stat_statements extension, which tracks every database on the
+	// server
+	err = pool.QueryRow(ctx, `
+SELECT coalesce(sum(calls), 0)::bigint, coalesce(sum(rows), 0)::bigint, coalesce(sum(total_exec_time), 0)::float8
+FROM pg_stat_statements
+WHERE dbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+  AND (query ILIKE '%INSERT INTO tasks%' OR query ILIKE 'COPY%tasks%')`).Scan(&stmts.calls, &stmts.rows, &stmts.execTime)
+
+	if err == nil {
+		s.statements = &stmts
+	}
+
+	return s
+}
+
+func int64Delta(before, after int64) *int64 {
+	d := after - before
+	return &d
+}
+
+// newServerReport computes the change between two snapshots for a run which wrote the
+// given number of rows
+func newServerReport(before, after *serverSnapshot, rows int) *ServerReport {
+	if before == nil || after == nil {
+		return nil
+	}
+
+	perRow := func(v int64) float64 {
+		if rows == 0 {
+			return 0
+		}
+
+		return float64(v) / float64(rows)
+	}
+
+	report := &ServerReport{
+		WALBytes: after.walPosition - before.walPosition,
+	}
+
+	report.WALBytesPerRow = perRow(report.WALBytes)
+
+	if before.wal != nil && after.wal != nil {
+		report.WALRecords = int64Delta(before.wal.records, after.wal.records)
+		report.WALFullPages = int64Delta(before.wal.fpi, after.wal.fpi)
+	}
+
+	if before.database != nil && after.database != nil {
+		report.Commits = int64Delta(before.database.commits, after.database.commits)
+		commitsPerRow := perRow(*report.Commits)
+		report.CommitsPerRow = &commitsPerRow
+		report.Rollbacks = int64Delta(before.database.rollbacks, after.database.rollbacks)
+		report.BlocksRead = int64Delta(before.database.blocksRead, after.database.blocksRead)
+		report.BlocksHit = int64Delta(before.database.blocksHit, after.database.blocksHit)
+		report.TuplesInserted = int64Delta(before.database.tuplesInserted, after.database.tuplesInserted)
+	}
+
+	if before.bgwriter != nil && after.bgwriter != nil {
+		report.Checkpoints = int64Delta(before.bgwriter.checkpoints, after.bgwriter.checkpoints)
+		report.BuffersWritten = int64Delta(before.bgwriter.buffersWritten, after.bgwriter.buffersWritten)
+	}
+
+	if before.statements != nil && after.statements != nil {
+		calls := after.statements.calls - before.statements.calls
+		stmtRows := after.statements.rows - before.statements.rows
+		execTime := time.Duration((after.statements.execTime - before.statements.execTime) * float64(time.Millisecond))
+
+		var mean, meanByRow time.Duration
+
+		if calls > 0 {
+			mean = execTime / time.Duration(calls)
+		}
+
+		if stmtRows > 0 {
+			meanByRow = execTime / time.Duration(stmtRows)
+		}
+
+		report.InsertStatement = &StatementReport{
+			Calls:             calls,
+			Rows:              stmtRows,
+			TotalExecTime:     execTime.String(),
+			MeanExecTime:      mean.String(),
+			MeanExecTimeByRow: meanByRow.String(),
+		}
+	}
+
+	return report
+}
+
+func printServerReport(s *ServerReport) {
+	fmt.Printf("Server WAL bytes: %d (%.2f per row)\n", s.WALBytes, s.WALBytesPerRow)
+
+	if s.WALRecords != nil {
+		fmt.Printf("Server WAL records: %d, full page images: %d\n", *s.WALRecords, *s.WALFullPages)
+	}
+
+	if s.Commits != nil {
+		fmt.Printf("Server commits: %d (%.4f per row), rollbacks: %d\n", *s.Commits, *s.CommitsPerRow, *s.Rollbacks)
+		fmt.Printf("Server blocks read: %d, hit: %d, tuples inserted: %d\n", *s.BlocksRead, *s.BlocksHit, *s.TuplesInserted)
+	}
+
+	if s.Checkpoints != nil {
+		fmt.Printf("Server checkpoints: %d, buffers written: %d\n", *s.Checkpoints, *s.BuffersWritten)
+	}
+
+	if s.InsertStatement != nil {
+		fmt.Printf("Server insert statements: %d calls, %d rows, mean execution time %s (%s per row)\n",
+			s.InsertStatement.Calls, s.InsertStatement.Rows, s.InsertStatement.MeanExecTime, s.InsertStatement.MeanExecTimeByRow)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/abelanger5/postgres-fast-inserts/internal/dbsqlc"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxBindParameters is the maximum number of bind parameters Postgres accepts in a
+// single statement
+const maxBindParameters = 65535
+
+// valuesColumns is the number of bind parameters per row in a VALUES statement
+const valuesColumns = 2
+
+// maxValuesRows is the largest number of rows which fit in a single VALUES statement
+const maxValuesRows = maxBindParameters / valuesColumns
+
+// valuesStatements caches the multi-row VALUES statement for each number of rows.
+// pgx prepares and caches each distinct statement per connection, so a fixed batch
+// size only pays for one prepare per connection.
+var valuesStatements sync.Map
+
+// valuesStatement returns an INSERT statement with a VALUES row for each of n rows
+func valuesStatement(n int) string {
+	if stmt, ok := valuesStatements.Load(n); ok {
+		return stmt.(string)
+	}
+
+	var b strings.Builder
+
+	b.WriteString("INSERT INTO tasks (args, idempotency_key) VALUES ")
+
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+
+		b.WriteString("($")
+		b.WriteString(strconv.Itoa(i*valuesColumns + 1))
+		b.WriteString(", $")
+		b.WriteString(strconv.Itoa(i*valuesColumns + 2))
+		b.WriteString(")")
+	}
+
+	b.WriteString(" RETURNING id, created_at, args, idempotency_key")
+
+	stmt, _ := valuesStatements.LoadOrStore(n, b.String())
+
+	return stmt.(string)
+}
+
+// insertValuesChunks inserts the tasks with as few VALUES statements as the bind
+// parameter limit allows, and returns the created rows in the same order as the tasks
+func insertValuesChunks(ctx context.Context, db dbsqlc.DBTX, tasks []TaskParams) ([]*dbsqlc.Task, error) {
+	resTasks := make([]*dbsqlc.Task, 0, len(tasks))
+
+	for start := 0; start < len(tasks); start += maxValuesRows {
+		chunk := tasks[start:min(start+maxValuesRows, len(tasks))]
+		args := make([]any, 0, len(chunk)*valuesColumns)
+
+		for _, task := range chunk {
+			args = append(args, task.Args, task.IdempotencyKey)
+		}
+
+		rows, err := db.Query(ctx, valuesStatement(len(chunk)), args...)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not create tasks values: %w", err)
+		}
+
+		created, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*dbsqlc.Task, error) {
+			var t dbsqlc.Task
+
+			err := row.Scan(&t.ID, &t.CreatedAt, &t.Args, &t.IdempotencyKey)
+
+			return &t, err
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("could not create tasks values: %w", err)
+		}
+
+		ordered, err := orderByKey(chunk, created)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not create tasks values: %w", err)
+		}
+
+		resTasks = append(resTasks, ordered...)
+	}
+
+	return resTasks, nil
+}
+
+func insertValues(ctx context.Context, tasks []TaskParams) ([]*dbsqlc.Task, error) {
+	// a single statement is atomic by itself
+	if len(tasks) <= maxValuesRows {
+		return insertValuesChunks(ctx, pool, tasks)
+	}
+
+	tx, err := pool.Begin(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	resTasks, err := insertValuesChunks(ctx, tx, tasks)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return resTasks, nil
+}
+
+func insertValuesWithAssociatedData(ctx context.Context, tasks []TaskParams) ([]*dbsqlc.Task, error) {
+	tx, err := pool.Begin(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	resTasks, err := insertValuesChunks(ctx, tx, tasks)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := insertAssociatedData(ctx, tx, resTasks); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return resTasks, nil
+}
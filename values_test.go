@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/abelanger5/postgres-fast-inserts/internal/dbsqlc"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// valuesDB answers multi-row VALUES inserts with the created rows in reverse order,
+// since Postgres doesn't guarantee that RETURNING follows the order of the VALUES
+type valuesDB struct {
+	dbsqlc.DBTX
+
+	chunks []int
+	nextID int64
+}
+
+func (db *valuesDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	if len(args) > maxBindParameters {
+		return nil, fmt.Errorf("%d bind parameters is over the limit", len(args))
+	}
+
+	n := len(args) / valuesColumns
+
+	if sql != valuesStatement(n) || strings.Count(sql, "$") != len(args) {
+		return nil, fmt.Errorf("statement does not match %d args", len(args))
+	}
+
+	db.chunks = append(db.chunks, n)
+
+	rows := &valuesRows{i: -1}
+
+	for i := n - 1; i >= 0; i-- {
+		db.nextID++
+
+		rows.tasks = append(rows.tasks, dbsqlc.Task{
+			ID:             db.nextID,
+			Args:           args[i*valuesColumns].(json.RawMessage),
+			IdempotencyKey: args[i*valuesColumns+1].(pgtype.Text),
+		})
+	}
+
+	return rows, nil
+}
+
+type valuesRows struct {
+	pgx.Rows
+
+	tasks []dbsqlc.Task
+	i     int
+}
+
+func (r *valuesRows) Next() bool {
+	r.i++
+	return r.i < len(r.tasks)
+}
+
+func (r *valuesRows) Scan(dest ...any) error {
+	t := r.tasks[r.i]
+
+	*dest[0].(*int64) = t.ID
+	*dest[2].(*json.RawMessage) = t.Args
+	*dest[3].(*pgtype.Text) = t.IdempotencyKey
+
+	return nil
+}
+
+func (r *valuesRows) Close() {}
+
+func (r *valuesRows) Err() error {
+	return nil
+}
+
+func TestInsertValuesChunks(t *testing.T) {
+	tests := []struct {
+		name       string
+		numTasks   int
+		wantChunks []int
+	}{
+		{name: "single row", numTasks: 1, wantChunks: []int{1}},
+		{name: "exactly one chunk", numTasks: maxValuesRows, wantChunks: []int{maxValuesRows}},
+		{name: "one row over", numTasks: maxValuesRows + 1, wantChunks: []int{maxValuesRows, 1}},
+		{name: "several chunks", numTasks: 2*maxValuesRows + 10, wantChunks: []int{maxValuesRows, maxValuesRows, 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks := make([]TaskParams, tt.numTasks)
+
+			for i := range tasks {
+				tasks[i] = TaskParams{
+					Args:           json.RawMessage(fmt.Sprintf(`{"i":%d}`, i)),
+					IdempotencyKey: pgtype.Text{String: fmt.Sprintf("key-%d", i), Valid: true},
+				}
+			}
+
+			db := &valuesDB{}
+
+			created, err := insertValuesChunks(context.Background(), db, tasks)
+
+			if err != nil {
+				t.Fatalf("insertValuesChunks returned an error: %v", err)
+			}
+
+			if fmt.Sprint(db.chunks) != fmt.Sprint(tt.wantChunks) {
+				t.Fatalf("got chunks %v, want %v", db.chunks, tt.wantChunks)
+			}
+
+			if len(created) != len(tasks) {
+				t.Fatalf("got %d rows, want %d", len(created), len(tasks))
+			}
+
+			for i, row := range created {
+				if row.IdempotencyKey != tasks[i].IdempotencyKey || string(row.Args) != string(tasks[i].Args) {
+					t.Fatalf("row %d: got key %s, want %s", i, row.IdempotencyKey.String, tasks[i].IdempotencyKey.String)
+				}
+			}
+		})
+	}
+}